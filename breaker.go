@@ -0,0 +1,19 @@
+package main
+
+import "sync/atomic"
+
+// circuitBreaker trips after limit consecutive genre failures. Any success
+// resets the count. A limit of zero never trips.
+type circuitBreaker struct {
+	limit       int32
+	consecutive int32
+}
+
+func (b *circuitBreaker) success() {
+	atomic.StoreInt32(&b.consecutive, 0)
+}
+
+// failure records a failed genre and reports whether the breaker has tripped.
+func (b *circuitBreaker) failure() bool {
+	return b.limit > 0 && atomic.AddInt32(&b.consecutive, 1) >= b.limit
+}
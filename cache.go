@@ -0,0 +1,136 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// pageCache stores fetched pages on disk together with the validators needed
+// to revalidate them with a conditional GET. With compress set, new bodies are
+// gzipped.
+type pageCache struct {
+	dir      string
+	compress bool
+}
+
+// cacheMeta is stored next to each body. Encoding records how that body was
+// written, so entries from runs with and without --cache-gzip can be mixed in
+// one directory.
+type cacheMeta struct {
+	URL          string `json:"url"`
+	ETag         string `json:"etag,omitempty"`
+	LastModified string `json:"last_modified,omitempty"`
+	Encoding     string `json:"encoding,omitempty"` // "gzip", or "" for raw HTML
+}
+
+func newPageCache(dir string, compress bool) (*pageCache, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	return &pageCache{dir: dir, compress: compress}, nil
+}
+
+// bodyPath returns the file holding a body written with encoding.
+func bodyPath(key, encoding string) string {
+	if encoding == "gzip" {
+		return key + ".html.gz"
+	}
+	return key + ".html"
+}
+
+func (c *pageCache) key(url string) string {
+	sum := sha256.Sum256([]byte(url))
+	return filepath.Join(c.dir, hex.EncodeToString(sum[:]))
+}
+
+// load returns the cached page for url, if any.
+func (c *pageCache) load(url string) (cacheMeta, []byte, bool) {
+	key := c.key(url)
+
+	raw, err := os.ReadFile(key + ".json")
+	if err != nil {
+		return cacheMeta{}, nil, false
+	}
+	var meta cacheMeta
+	if err := json.Unmarshal(raw, &meta); err != nil || meta.URL != url {
+		return cacheMeta{}, nil, false
+	}
+
+	body, err := os.ReadFile(bodyPath(key, meta.Encoding))
+	if err != nil {
+		return cacheMeta{}, nil, false
+	}
+	switch meta.Encoding {
+	case "":
+	case "gzip":
+		gz, err := gzip.NewReader(bytes.NewReader(body))
+		if err != nil {
+			return cacheMeta{}, nil, false
+		}
+		if body, err = io.ReadAll(gz); err != nil {
+			return cacheMeta{}, nil, false
+		}
+	default:
+		return cacheMeta{}, nil, false
+	}
+	return meta, body, true
+}
+
+// store writes the page body before its metadata so that a crash between the
+// two never leaves metadata pointing at a missing body. A body left over in
+// the other encoding is removed once the metadata no longer points at it.
+func (c *pageCache) store(meta cacheMeta, body []byte) error {
+	key := c.key(meta.URL)
+
+	stale := bodyPath(key, "gzip")
+	if c.compress {
+		meta.Encoding = "gzip"
+		stale = bodyPath(key, "")
+		var buf bytes.Buffer
+		gz := gzip.NewWriter(&buf)
+		gz.Write(body)
+		if err := gz.Close(); err != nil {
+			return err
+		}
+		body = buf.Bytes()
+	}
+
+	raw, err := json.Marshal(meta)
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomic(bodyPath(key, meta.Encoding), body); err != nil {
+		return err
+	}
+	if err := writeFileAtomic(key+".json", raw); err != nil {
+		return err
+	}
+	os.Remove(stale)
+	return nil
+}
+
+// remove drops the cached copy of url, so the next fetch isn't conditional.
+func (c *pageCache) remove(url string) {
+	key := c.key(url)
+	os.Remove(key + ".json")
+	os.Remove(bodyPath(key, ""))
+	os.Remove(bodyPath(key, "gzip"))
+}
+
+func writeFileAtomic(path string, data []byte) error {
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return errors.Join(err, os.Remove(tmp))
+	}
+	return nil
+}
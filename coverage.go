@@ -0,0 +1,50 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+// coverageReport summarizes how much of the genre list a run covered, for
+// --coverage-out. Genres counts the genres in scope: the whole map, or one
+// --shard of it, less the Duplicates whose page was already queued. Scraped
+// includes genres written by an earlier run when --only-missing is set.
+type coverageReport struct {
+	FinishedAt    string   `json:"finished_at"`
+	Genres        int      `json:"genres"`
+	Scraped       int      `json:"scraped"`
+	Failed        int      `json:"failed"`
+	Missing       int      `json:"missing"`
+	Duplicates    int      `json:"duplicates"`
+	Coverage      float64  `json:"coverage_percent"`
+	MissingGenres []string `json:"missing_genres"`
+}
+
+func newCoverageReport(p *progress, duplicates int) coverageReport {
+	missing := p.missingPages()
+	report := coverageReport{
+		FinishedAt:    time.Now().UTC().Format(time.RFC3339),
+		Genres:        int(p.total),
+		Scraped:       int(atomic.LoadInt32(&p.done)),
+		Failed:        int(p.failures()),
+		Missing:       len(missing),
+		Duplicates:    duplicates,
+		MissingGenres: nonNil(missing),
+	}
+	if report.Genres > 0 {
+		// Rounded down, so a run is only reported at 100% when nothing is missing
+		report.Coverage = math.Floor(10000*float64(report.Scraped)/float64(report.Genres)) / 100
+	}
+	return report
+}
+
+func (r coverageReport) write(path string) error {
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0o644)
+}
@@ -0,0 +1,32 @@
+package main
+
+import "errors"
+
+// Kinds of genre failures. Errors from scrapeGenrePage and scrapeGenreData
+// wrap one of these, so callers can use errors.Is to tell them apart, e.g. to
+// retry network errors but skip missing pages.
+var (
+	// errPageMissing is returned for a genre that is listed on the map but
+	// whose page doesn't exist (404 or 410) or has no playlist, artists or
+	// related genres. That is an inconsistency on everynoise rather than a
+	// failed request.
+	errPageMissing = errors.New("genre page is missing or empty")
+
+	// errThrottled is a 429 response or a page matching --throttle-pattern.
+	errThrottled = errors.New("throttled")
+
+	// errNetwork is a request that failed to complete, including one cut short
+	// by a cancelled or timed-out context, a 5xx response or any other status
+	// that isn't a genre page.
+	errNetwork = errors.New("network error")
+
+	// errForbidden is a 401 or 403 response, e.g. a wrong --bearer-token or
+	// --basic-auth, or an anti-bot block. Unlike a missing page it counts
+	// towards --max-consecutive-failures.
+	errForbidden = errors.New("unauthorized or forbidden")
+
+	// errParseFailed is a response that can't be parsed as a genre page, e.g.
+	// because it isn't HTML, is larger than --max-body-bytes or isn't valid
+	// gzip.
+	errParseFailed = errors.New("parse failed")
+)
@@ -0,0 +1,428 @@
+package main
+
+import (
+	"compress/gzip"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// assumedLatency is the typical time to fetch and parse one genre page, used
+// by --workers-auto.
+const assumedLatency = 500 * time.Millisecond
+
+// autoWorkers returns enough workers to keep one request in flight per rate
+// limiter token: the limiter serializes requests anyway, so more workers only
+// sit blocked in limiter.Wait.
+func autoWorkers(interval time.Duration) int {
+	if interval <= 0 {
+		return 64
+	}
+	workers := int((assumedLatency + interval - 1) / interval)
+	return max(1, min(workers, 64))
+}
+
+// setupFetch configures the shared HTTP client, page cache and artist filter
+// from flags.
+func setupFetch() error {
+	limiter.SetLimit(rate.Every(*rateInterval))
+	if err := configureTransport(); err != nil {
+		return err
+	}
+	filter, err := newArtistFilter(*includeArtists, *excludeArtists, *fuzzyArtists)
+	if err != nil {
+		return fmt.Errorf("error reading artist filter: %v", err)
+	}
+	artistsFilter = filter
+
+	if err := setupAuth(); err != nil {
+		return err
+	}
+
+	if *throttleRegexp != "" {
+		re, err := regexp.Compile(*throttleRegexp)
+		if err != nil {
+			return fmt.Errorf("invalid --throttle-pattern: %v", err)
+		}
+		throttlePattern = re
+	}
+
+	if *cacheDir != "" {
+		c, err := newPageCache(*cacheDir, *cacheGzip)
+		if err != nil {
+			return fmt.Errorf("error creating cache directory: %v", err)
+		}
+		cache = c
+	}
+	return nil
+}
+
+// rampFloor is the fraction of the --rate-interval rate a --warmup ramp
+// starts from.
+const rampFloor = 0.1
+
+// rampUp lowers the limiter to a tenth of the configured rate and raises it
+// linearly to the full rate over warmup, so a run doesn't start at full speed.
+// The returned function stops the ramp early and restores the full rate.
+func rampUp(warmup time.Duration) (stop func()) {
+	full := rate.Every(*rateInterval)
+	limiter.SetLimit(full * rampFloor)
+
+	step := max(warmup/20, 100*time.Millisecond)
+	ticker := time.NewTicker(step)
+	done := make(chan struct{})
+	finished := make(chan struct{})
+	start := time.Now()
+	go func() {
+		defer close(finished)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				progress := float64(time.Since(start)) / float64(warmup)
+				if progress >= 1 {
+					limiter.SetLimit(full)
+					log.Printf("Warm-up done, now at one request every %v", *rateInterval)
+					return
+				}
+				limiter.SetLimit(full * rate.Limit(rampFloor+(1-rampFloor)*progress))
+			case <-done:
+				limiter.SetLimit(full)
+				return
+			}
+		}
+	}()
+	return func() {
+		close(done)
+		<-finished
+	}
+}
+
+// configureTransport applies the connection and TLS flags to the shared HTTP
+// client.
+func configureTransport() error {
+	transport := httpClient.Transport.(*http.Transport)
+	transport.MaxIdleConns = *maxIdleConns
+	transport.MaxIdleConnsPerHost = *maxIdlePerHost
+	transport.MaxConnsPerHost = *maxConnsPerHost
+	transport.IdleConnTimeout = *idleConnTimeout
+	transport.ForceAttemptHTTP2 = *forceHTTP2
+
+	if *insecureTLS || *caCertFile != "" {
+		transport.TLSClientConfig = &tls.Config{}
+	}
+
+	if *insecureTLS {
+		log.Println("WARNING: TLS certificate verification is DISABLED (--insecure). Responses can be intercepted or forged.")
+		transport.TLSClientConfig.InsecureSkipVerify = true
+	}
+
+	if *caCertFile != "" {
+		pem, err := os.ReadFile(*caCertFile)
+		if err != nil {
+			return fmt.Errorf("error reading CA cert file: %v", err)
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("no certificates found in %s", *caCertFile)
+		}
+		transport.TLSClientConfig.RootCAs = pool
+	}
+
+	return nil
+}
+
+// authorization is the Authorization header sent with every request, set
+// from --basic-auth or --bearer-token. It is never logged.
+var authorization string
+
+// setupAuth builds the Authorization header. Either flag can name a file as
+// @path, which keeps the credentials out of the process list.
+func setupAuth() error {
+	if *basicAuth != "" && *bearerToken != "" {
+		return fmt.Errorf("--basic-auth and --bearer-token cannot be used together")
+	}
+	value, err := readSecret(*basicAuth)
+	if err != nil {
+		return fmt.Errorf("error reading --basic-auth: %v", err)
+	}
+	if value != "" {
+		user, password, ok := strings.Cut(value, ":")
+		if !ok {
+			return fmt.Errorf("--basic-auth must be user:password")
+		}
+		req := &http.Request{Header: make(http.Header)}
+		req.SetBasicAuth(user, password)
+		authorization = req.Header.Get("Authorization")
+		return nil
+	}
+
+	token, err := readSecret(*bearerToken)
+	if err != nil {
+		return fmt.Errorf("error reading --bearer-token: %v", err)
+	}
+	if token != "" {
+		authorization = "Bearer " + token
+	}
+	return nil
+}
+
+// readSecret returns value, or the trimmed contents of the file for @path.
+func readSecret(value string) (string, error) {
+	path, ok := strings.CutPrefix(value, "@")
+	if !ok {
+		return value, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+// setAuth adds the configured Authorization header to req. The client drops
+// it if a redirect leads to another host.
+func setAuth(req *http.Request) {
+	if authorization != "" {
+		req.Header.Set("Authorization", authorization)
+	}
+}
+
+var (
+	cache           *pageCache
+	notModifiedHits int32
+
+	// throttlePattern matches a 200 response that is really the site asking
+	// us to slow down, from --throttle-pattern.
+	throttlePattern *regexp.Regexp
+)
+
+// retryableError marks a failed attempt worth repeating: a network error, a
+// 429/5xx response or a page matching --throttle-pattern. after is the delay
+// the server asked for, if any.
+type retryableError struct {
+	err   error
+	after time.Duration
+}
+
+func (e *retryableError) Error() string { return e.err.Error() }
+func (e *retryableError) Unwrap() error { return e.err }
+
+// fetchPage GETs url, retrying network errors, 429/5xx responses and
+// throttling pages up to --retries times.
+//
+// Every attempt, including retries, waits for one rate limiter token just
+// before it is sent, so the overall request rate never exceeds
+// --rate-interval. The backoff between attempts is a plain sleep that holds
+// no token: other workers keep using the limiter while a genre backs off,
+// and a retry is paced like any other request once its backoff ends.
+func fetchPage(ctx context.Context, url string) ([]byte, error) {
+	for attempt := 0; ; attempt++ {
+		waitStart := time.Now()
+		err := limiter.Wait(ctx)
+		addTime(&limiterTime, waitStart)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", errNetwork, err)
+		}
+
+		requestStart := time.Now()
+		body, err := fetchOnce(ctx, url)
+		addTime(&requestTime, requestStart)
+		var retry *retryableError
+		if err == nil || !errors.As(err, &retry) || attempt >= *maxRetries || ctx.Err() != nil {
+			return body, err
+		}
+
+		delay := retryDelay(attempt, retry.after)
+		log.Printf("Retrying %s in %v: %v", url, delay, err)
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return nil, fmt.Errorf("%w: %w", errNetwork, ctx.Err())
+		}
+	}
+}
+
+// retryDelay doubles --retry-backoff on every attempt, up to a minute, but
+// never waits less than the server's Retry-After.
+func retryDelay(attempt int, after time.Duration) time.Duration {
+	delay := *retryBackoff << attempt
+	if delay <= 0 || delay > time.Minute {
+		delay = time.Minute
+	}
+	return max(delay, after)
+}
+
+// retryAfter parses a Retry-After header given in seconds or as an HTTP date.
+func retryAfter(header http.Header) time.Duration {
+	value := header.Get("Retry-After")
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		return time.Duration(seconds) * time.Second
+	}
+	if t, err := http.ParseTime(value); err == nil {
+		return time.Until(t)
+	}
+	return 0
+}
+
+// fetchOnce GETs url and returns its body, capped at --max-body-bytes. Bodies
+// that are not HTML, and responses other than 200, are rejected, since they
+// would parse to an empty page. With
+// --cache-dir set, a cached copy is revalidated using If-None-Match and
+// If-Modified-Since, and a 304 response returns the cached body.
+func fetchOnce(ctx context.Context, url string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", errNetwork, err)
+	}
+	setAuth(req)
+
+	var cachedBody []byte
+	if cache != nil {
+		if meta, body, ok := cache.load(url); ok {
+			cachedBody = body
+			if meta.ETag != "" {
+				req.Header.Set("If-None-Match", meta.ETag)
+			}
+			if meta.LastModified != "" {
+				req.Header.Set("If-Modified-Since", meta.LastModified)
+			}
+		}
+	}
+
+	start := time.Now()
+	res, err := httpClient.Do(req)
+	if err != nil {
+		if *verbose {
+			log.Printf("GET %s failed after %v: %v", url, time.Since(start), err)
+		}
+		return nil, &retryableError{err: fmt.Errorf("%w: %w", errNetwork, err)}
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode == http.StatusNotModified {
+		if cachedBody == nil {
+			return nil, fmt.Errorf("%w: unexpected 304 response without a cached copy", errNetwork)
+		}
+		if *verbose {
+			log.Printf("GET %s: %d, %d cached bytes, %v", res.Request.URL, res.StatusCode, len(cachedBody), time.Since(start))
+		}
+		atomic.AddInt32(&notModifiedHits, 1)
+		return cachedBody, nil
+	}
+
+	body, err := readResponse(res)
+	if *verbose {
+		log.Printf("GET %s: %d, %s, %d bytes, %v", res.Request.URL, res.StatusCode, res.Header.Get("Content-Type"), len(body), time.Since(start))
+	}
+	if res.StatusCode == http.StatusTooManyRequests {
+		return nil, &retryableError{err: fmt.Errorf("%w: HTTP %s", errThrottled, res.Status), after: retryAfter(res.Header)}
+	}
+	if res.StatusCode >= 500 {
+		return nil, &retryableError{err: fmt.Errorf("%w: HTTP %s", errNetwork, res.Status), after: retryAfter(res.Header)}
+	}
+	if err != nil {
+		// A connection reset or timeout partway through the body is retried
+		// like one before the headers; a body over --max-body-bytes is not
+		if errors.Is(err, errNetwork) {
+			return nil, &retryableError{err: err}
+		}
+		return nil, err
+	}
+	switch {
+	case res.StatusCode == http.StatusNotFound || res.StatusCode == http.StatusGone:
+		return nil, fmt.Errorf("%w: HTTP %s", errPageMissing, res.Status)
+	case res.StatusCode == http.StatusUnauthorized || res.StatusCode == http.StatusForbidden:
+		return nil, fmt.Errorf("%w: HTTP %s", errForbidden, res.Status)
+	case res.StatusCode != http.StatusOK:
+		// Only a 200 is a genre page; anything else would parse to an empty
+		// page and be mistaken for a missing one
+		return nil, fmt.Errorf("%w: unexpected HTTP %s", errNetwork, res.Status)
+	}
+	if contentType := bodyContentType(res, body); !isHTML(contentType) {
+		log.Printf("Unexpected content type %q from %s", contentType, res.Request.URL)
+		return nil, fmt.Errorf("%w: unexpected content type %q", errParseFailed, contentType)
+	}
+	if throttlePattern != nil && throttlePattern.Match(body) {
+		return nil, &retryableError{err: fmt.Errorf("%w: response body matches --throttle-pattern", errThrottled)}
+	}
+
+	if cache != nil {
+		meta := cacheMeta{
+			URL:          url,
+			ETag:         res.Header.Get("ETag"),
+			LastModified: res.Header.Get("Last-Modified"),
+		}
+		if err := cache.store(meta, body); err != nil {
+			log.Printf("Error caching %s: %v", url, err)
+		}
+	}
+
+	return body, nil
+}
+
+// readResponse reads a response body, decompressing it if it is still
+// gzip-encoded. The transport only decodes gzip it asked for itself, so a
+// server that gzips regardless, e.g. on its error pages, would otherwise hand
+// compressed bytes to the HTML parser.
+func readResponse(res *http.Response) ([]byte, error) {
+	if res.Uncompressed || !strings.EqualFold(res.Header.Get("Content-Encoding"), "gzip") {
+		return readBody(res.Body)
+	}
+	gz, err := gzip.NewReader(res.Body)
+	if err != nil {
+		return nil, fmt.Errorf("%w: error decoding gzip body: %v", errParseFailed, err)
+	}
+	defer gz.Close()
+	return readBody(gz)
+}
+
+// bodyContentType returns the response's declared media type, sniffing the
+// body when the server sends none.
+func bodyContentType(res *http.Response, body []byte) string {
+	contentType := res.Header.Get("Content-Type")
+	if contentType == "" {
+		contentType = http.DetectContentType(body)
+	}
+	if mediaType, _, err := mime.ParseMediaType(contentType); err == nil {
+		return mediaType
+	}
+	return contentType
+}
+
+func isHTML(mediaType string) bool {
+	return mediaType == "text/html" || mediaType == "application/xhtml+xml"
+}
+
+// readBody reads a response body, failing if it is larger than
+// --max-body-bytes rather than reading an unbounded response into memory.
+func readBody(body io.Reader) ([]byte, error) {
+	data, err := io.ReadAll(io.LimitReader(body, *maxBodyBytes+1))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", errNetwork, err)
+	}
+	if int64(len(data)) > *maxBodyBytes {
+		return nil, fmt.Errorf("%w: response body exceeds %d bytes", errParseFailed, *maxBodyBytes)
+	}
+	return data, nil
+}
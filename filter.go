@@ -0,0 +1,197 @@
+package main
+
+import (
+	"bufio"
+	"os"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// artistFilter drops artists from each genre's parallel Artists,
+// ArtistWeights and ArtistPreviews slices. A non-empty include list keeps only the listed
+// artists; the exclude list is applied afterwards. Names match
+// case-insensitively, or loosely with fuzzy set (see fuzzyKey).
+type artistFilter struct {
+	include []string
+	exclude []string
+	fuzzy   bool
+}
+
+// artistsFilter is nil unless --include-artists or --exclude-artists is set.
+var artistsFilter *artistFilter
+
+func newArtistFilter(include, exclude string, fuzzy bool) (*artistFilter, error) {
+	if include == "" && exclude == "" {
+		return nil, nil
+	}
+	f := &artistFilter{fuzzy: fuzzy}
+	var err error
+	if f.include, err = readNameList(include); err != nil {
+		return nil, err
+	}
+	if f.exclude, err = readNameList(exclude); err != nil {
+		return nil, err
+	}
+	for i := range f.include {
+		f.include[i] = f.key(f.include[i])
+	}
+	for i := range f.exclude {
+		f.exclude[i] = f.key(f.exclude[i])
+	}
+	return f, nil
+}
+
+// readNameList parses a comma-separated list of names, or with a leading @
+// reads one name per line from that file.
+func readNameList(value string) ([]string, error) {
+	if value == "" {
+		return nil, nil
+	}
+	if !strings.HasPrefix(value, "@") {
+		var names []string
+		for _, name := range strings.Split(value, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				names = append(names, name)
+			}
+		}
+		return names, nil
+	}
+
+	file, err := os.Open(value[1:])
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var names []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		if name := strings.TrimSpace(scanner.Text()); name != "" {
+			names = append(names, name)
+		}
+	}
+	return names, scanner.Err()
+}
+
+// apply filters artists and their weights, keeping the two slices aligned.
+func (f *artistFilter) apply(genre Genre) Genre {
+	names, weights, previews := genre.Artists, genre.ArtistWeights, genre.ArtistPreviews
+	genre.Artists, genre.ArtistWeights, genre.ArtistPreviews = nil, nil, nil
+	for i, name := range names {
+		key := f.key(name)
+		if len(f.include) > 0 && !f.matches(f.include, key) {
+			continue
+		}
+		if f.matches(f.exclude, key) {
+			continue
+		}
+		genre.Artists = append(genre.Artists, name)
+		genre.ArtistWeights = append(genre.ArtistWeights, weights[i])
+		if previews != nil {
+			genre.ArtistPreviews = append(genre.ArtistPreviews, previews[i])
+		}
+	}
+	return genre
+}
+
+func (f *artistFilter) key(name string) string {
+	if f.fuzzy {
+		return fuzzyKey(name)
+	}
+	return strings.ToLower(strings.TrimSpace(name))
+}
+
+func (f *artistFilter) matches(list []string, key string) bool {
+	for _, candidate := range list {
+		if candidate == key {
+			return true
+		}
+		if f.fuzzy && len(key) > 3 && editDistance(candidate, key) <= 1 {
+			return true
+		}
+	}
+	return false
+}
+
+// fuzzyKey lowercases name and drops everything but letters and digits, so
+// "Sigur Rós" and "sigur-rós" share a key. Fuzzy matching
+// additionally accepts keys one edit apart.
+func fuzzyKey(name string) string {
+	var b strings.Builder
+	for _, r := range strings.ToLower(name) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// editDistance is the Levenshtein distance between a and b.
+func editDistance(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	prev := make([]int, len(rb)+1)
+	curr := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		curr[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			curr[j] = min(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+		}
+		prev, curr = curr, prev
+	}
+	return prev[len(rb)]
+}
+
+// heavyIndexes returns the indexes of the weights that are at least min.
+// Weights that aren't numbers are kept, since there is nothing to compare.
+func heavyIndexes(weights []string, min float64) []int {
+	var kept []int
+	for i, w := range weights {
+		n, err := strconv.ParseFloat(w, 64)
+		if err != nil || n >= min {
+			kept = append(kept, i)
+		}
+	}
+	return kept
+}
+
+// pick returns the values at indexes, keeping a nil slice nil so optional
+// parallel slices stay unset.
+func pick(values []string, indexes []int) []string {
+	if values == nil {
+		return nil
+	}
+	picked := make([]string, 0, len(indexes))
+	for _, i := range indexes {
+		picked = append(picked, values[i])
+	}
+	return picked
+}
+
+// dropLightWeights removes artists below --min-artist-weight and similar and
+// opposite genres below --min-genre-weight, keeping the parallel slices
+// aligned.
+func dropLightWeights(genre Genre) Genre {
+	if *minArtistWeight > 0 {
+		kept := heavyIndexes(genre.ArtistWeights, *minArtistWeight)
+		genre.Artists = pick(genre.Artists, kept)
+		genre.ArtistWeights = pick(genre.ArtistWeights, kept)
+		genre.ArtistPreviews = pick(genre.ArtistPreviews, kept)
+	}
+	if *minGenreWeight > 0 {
+		kept := heavyIndexes(genre.SimWeights, *minGenreWeight)
+		genre.SimGenres = pick(genre.SimGenres, kept)
+		genre.SimWeights = pick(genre.SimWeights, kept)
+		kept = heavyIndexes(genre.OppWeights, *minGenreWeight)
+		genre.OppGenres = pick(genre.OppGenres, kept)
+		genre.OppWeights = pick(genre.OppWeights, kept)
+	}
+	return genre
+}
@@ -4,11 +4,20 @@ go 1.22
 
 require (
 	github.com/PuerkitoBio/goquery v1.9.2
+	github.com/parquet-go/parquet-go v0.24.0
 	golang.org/x/sync v0.1.0
 	golang.org/x/time v0.6.0
 )
 
 require (
+	github.com/andybalholm/brotli v1.1.0 // indirect
 	github.com/andybalholm/cascadia v1.3.2 // indirect
+	github.com/google/uuid v1.6.0 // indirect
+	github.com/klauspost/compress v1.17.9 // indirect
+	github.com/mattn/go-runewidth v0.0.15 // indirect
+	github.com/olekukonko/tablewriter v0.0.5 // indirect
+	github.com/pierrec/lz4/v4 v4.1.21 // indirect
+	github.com/rivo/uniseg v0.4.7 // indirect
 	golang.org/x/net v0.24.0 // indirect
+	golang.org/x/sys v0.21.0 // indirect
 )
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"bufio"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// The dot and graphml formats write the genre map as a graph: one node per
+// genre and a directed edge to each of its similar and opposite genres.
+// Genre names are used as node IDs, so they are escaped for each format;
+// names such as r&b, "nightcore" or <3 must not break the output.
+
+// dotQuote returns s as a quoted DOT ID. Inside quotes only the quote itself
+// needs escaping, and backslashes are doubled so a name ending in one can't
+// escape the closing quote.
+func dotQuote(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	s = strings.ReplaceAll(s, `"`, `\"`)
+	s = strings.ReplaceAll(s, "\n", `\n`)
+	return `"` + s + `"`
+}
+
+// xmlEscape escapes s for use in GraphML element text or attribute values.
+func xmlEscape(s string) string {
+	var b strings.Builder
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
+
+type dotWriter struct {
+	out io.Writer
+	buf *bufio.Writer
+}
+
+func newDOTWriter(out io.Writer) *dotWriter {
+	w := &dotWriter{out: out, buf: bufio.NewWriter(out)}
+	w.buf.WriteString("digraph genres {\n")
+	return w
+}
+
+func (w *dotWriter) Write(genre Genre) error {
+	fmt.Fprintf(w.buf, "  %s [label=%s", dotQuote(genre.Name), dotQuote(genre.Name))
+	if genre.ColorHex != "" {
+		fmt.Fprintf(w.buf, ", color=%s", dotQuote(genre.ColorHex))
+	}
+	w.buf.WriteString("];\n")
+	w.writeEdges(genre.Name, "similar", genre.SimGenres, genre.SimWeights)
+	w.writeEdges(genre.Name, "opposite", genre.OppGenres, genre.OppWeights)
+	return nil
+}
+
+func (w *dotWriter) writeEdges(source, relation string, targets, weights []string) {
+	for i, target := range targets {
+		var weight string
+		if i < len(weights) {
+			weight = weights[i]
+		}
+		fmt.Fprintf(w.buf, "  %s -> %s [relation=%s, weight=%s];\n", dotQuote(source), dotQuote(target), relation, dotQuote(weight))
+	}
+}
+
+func (w *dotWriter) Flush() error {
+	if err := w.buf.Flush(); err != nil {
+		return err
+	}
+	return flushOutput(w.out)
+}
+
+func (w *dotWriter) Close() error {
+	w.buf.WriteString("}\n")
+	if err := w.buf.Flush(); err != nil {
+		closeOutput(w.out)
+		return err
+	}
+	return closeOutput(w.out)
+}
+
+// graphMLWriter streams nodes and edges as they are written. An edge can name
+// a genre that is never written, e.g. one that failed to scrape, so those
+// nodes are declared on Close.
+type graphMLWriter struct {
+	out      io.Writer
+	buf      *bufio.Writer
+	declared map[string]bool
+	targets  []string
+	edges    int
+}
+
+func newGraphMLWriter(out io.Writer) *graphMLWriter {
+	w := &graphMLWriter{out: out, buf: bufio.NewWriter(out), declared: make(map[string]bool)}
+	w.buf.WriteString(xml.Header)
+	w.buf.WriteString(`<graphml xmlns="http://graphml.graphdrawing.org/xmlns">` + "\n")
+	w.buf.WriteString(`  <key id="name" for="node" attr.name="name" attr.type="string"/>` + "\n")
+	w.buf.WriteString(`  <key id="color" for="node" attr.name="color" attr.type="string"/>` + "\n")
+	w.buf.WriteString(`  <key id="relation" for="edge" attr.name="relation" attr.type="string"/>` + "\n")
+	w.buf.WriteString(`  <key id="weight" for="edge" attr.name="weight" attr.type="string"/>` + "\n")
+	w.buf.WriteString(`  <graph id="genres" edgedefault="directed">` + "\n")
+	return w
+}
+
+func (w *graphMLWriter) Write(genre Genre) error {
+	if !w.declared[genre.Name] {
+		w.declared[genre.Name] = true
+		w.writeNode(genre.Name, genre.ColorHex)
+	}
+	w.writeEdges(genre.Name, "similar", genre.SimGenres, genre.SimWeights)
+	w.writeEdges(genre.Name, "opposite", genre.OppGenres, genre.OppWeights)
+	return nil
+}
+
+func (w *graphMLWriter) writeNode(name, color string) {
+	fmt.Fprintf(w.buf, `    <node id="%s"><data key="name">%s</data>`, xmlEscape(name), xmlEscape(name))
+	if color != "" {
+		fmt.Fprintf(w.buf, `<data key="color">%s</data>`, xmlEscape(color))
+	}
+	w.buf.WriteString("</node>\n")
+}
+
+func (w *graphMLWriter) writeEdges(source, relation string, targets, weights []string) {
+	for i, target := range targets {
+		var weight string
+		if i < len(weights) {
+			weight = weights[i]
+		}
+		w.edges++
+		fmt.Fprintf(w.buf, `    <edge id="e%d" source="%s" target="%s"><data key="relation">%s</data><data key="weight">%s</data></edge>`+"\n",
+			w.edges, xmlEscape(source), xmlEscape(target), relation, xmlEscape(weight))
+		w.targets = append(w.targets, target)
+	}
+}
+
+func (w *graphMLWriter) Flush() error {
+	if err := w.buf.Flush(); err != nil {
+		return err
+	}
+	return flushOutput(w.out)
+}
+
+func (w *graphMLWriter) Close() error {
+	for _, target := range w.targets {
+		if !w.declared[target] {
+			w.declared[target] = true
+			w.writeNode(target, "")
+		}
+	}
+	w.buf.WriteString("  </graph>\n</graphml>\n")
+	if err := w.buf.Flush(); err != nil {
+		closeOutput(w.out)
+		return err
+	}
+	return closeOutput(w.out)
+}
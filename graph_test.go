@@ -0,0 +1,142 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"io"
+	"testing"
+)
+
+func TestDOTQuote(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"rock", `"rock"`},
+		{"r&b", `"r&b"`},
+		{`"nightcore"`, `"\"nightcore\""`},
+		{"<>", `"<>"`},
+		{`trailing\`, `"trailing\\"`},
+		{"two\nlines", `"two\nlines"`},
+	}
+	for _, tt := range tests {
+		if got := dotQuote(tt.in); got != tt.want {
+			t.Errorf("dotQuote(%q) = %s, want %s", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestXMLEscape(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"rock", "rock"},
+		{"r&b", "r&amp;b"},
+		{`"nightcore"`, "&#34;nightcore&#34;"},
+		{"<>", "&lt;&gt;"},
+	}
+	for _, tt := range tests {
+		if got := xmlEscape(tt.in); got != tt.want {
+			t.Errorf("xmlEscape(%q) = %s, want %s", tt.in, got, tt.want)
+		}
+	}
+}
+
+// graphGenres have names that need escaping in both formats, and a similar
+// genre without a weight.
+var graphGenres = []Genre{
+	{
+		Name:       "r&b",
+		ColorHex:   "#aa0000",
+		SimGenres:  []string{`"nightcore"`, "<>"},
+		SimWeights: []string{"10"},
+		OppGenres:  []string{"rock"},
+		OppWeights: []string{"2"},
+	},
+	{
+		Name:     `"nightcore"`,
+		ColorHex: "#00aa00",
+	},
+}
+
+func writeGraph(t *testing.T, w genreWriter) {
+	t.Helper()
+	for _, genre := range graphGenres {
+		if err := w.Write(genre); err != nil {
+			t.Fatalf("Write(%s): %v", genre.Name, err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+}
+
+func TestDOTWriter(t *testing.T) {
+	var out bytes.Buffer
+	writeGraph(t, newDOTWriter(&out))
+
+	want := `digraph genres {
+  "r&b" [label="r&b", color="#aa0000"];
+  "r&b" -> "\"nightcore\"" [relation=similar, weight="10"];
+  "r&b" -> "<>" [relation=similar, weight=""];
+  "r&b" -> "rock" [relation=opposite, weight="2"];
+  "\"nightcore\"" [label="\"nightcore\"", color="#00aa00"];
+}
+`
+	if got := out.String(); got != want {
+		t.Errorf("DOT output:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestGraphMLWriter(t *testing.T) {
+	var out bytes.Buffer
+	writeGraph(t, newGraphMLWriter(&out))
+
+	want := xml.Header + `<graphml xmlns="http://graphml.graphdrawing.org/xmlns">
+  <key id="name" for="node" attr.name="name" attr.type="string"/>
+  <key id="color" for="node" attr.name="color" attr.type="string"/>
+  <key id="relation" for="edge" attr.name="relation" attr.type="string"/>
+  <key id="weight" for="edge" attr.name="weight" attr.type="string"/>
+  <graph id="genres" edgedefault="directed">
+    <node id="r&amp;b"><data key="name">r&amp;b</data><data key="color">#aa0000</data></node>
+    <edge id="e1" source="r&amp;b" target="&#34;nightcore&#34;"><data key="relation">similar</data><data key="weight">10</data></edge>
+    <edge id="e2" source="r&amp;b" target="&lt;&gt;"><data key="relation">similar</data><data key="weight"></data></edge>
+    <edge id="e3" source="r&amp;b" target="rock"><data key="relation">opposite</data><data key="weight">2</data></edge>
+    <node id="&#34;nightcore&#34;"><data key="name">&#34;nightcore&#34;</data><data key="color">#00aa00</data></node>
+    <node id="&lt;&gt;"><data key="name">&lt;&gt;</data></node>
+    <node id="rock"><data key="name">rock</data></node>
+  </graph>
+</graphml>
+`
+	if got := out.String(); got != want {
+		t.Errorf("GraphML output:\n%s\nwant:\n%s", got, want)
+	}
+
+	// The escaped names must survive a round trip through an XML parser
+	var ids []string
+	decoder := xml.NewDecoder(&out)
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("output is not well-formed XML: %v", err)
+		}
+		if start, ok := token.(xml.StartElement); ok && start.Name.Local == "node" {
+			for _, attr := range start.Attr {
+				if attr.Name.Local == "id" {
+					ids = append(ids, attr.Value)
+				}
+			}
+		}
+	}
+	wantIDs := []string{"r&b", `"nightcore"`, "<>", "rock"}
+	if len(ids) != len(wantIDs) {
+		t.Fatalf("node IDs = %q, want %q", ids, wantIDs)
+	}
+	for i := range ids {
+		if ids[i] != wantIDs[i] {
+			t.Errorf("node ID %d = %q, want %q", i, ids[i], wantIDs[i])
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+
+	"golang.org/x/sync/errgroup"
+)
+
+// headCheckGenres requests every genre page with HEAD instead of scraping it
+// and writes genre,url,status,exists rows as CSV to stdout in input order.
+// Servers that reject HEAD are asked again with a GET whose body is discarded.
+func headCheckGenres(genres []Genre, workers int) int {
+	statuses := make([]int, len(genres))
+	errs := make([]error, len(genres))
+
+	var g errgroup.Group
+	g.SetLimit(workers)
+	for i, genre := range genres {
+		i, genre := i, genre
+		if genre.URL == "" {
+			genre.URL = genreURL(everynoiseBase, genre.Name)
+			genres[i] = genre
+		}
+		g.Go(func() error {
+			statuses[i], errs[i] = checkPage(context.Background(), genre.URL)
+			return nil
+		})
+	}
+	g.Wait()
+
+	writer := csv.NewWriter(os.Stdout)
+	writer.Write([]string{"Genre", "URL", "Status", "Exists"})
+	missing, failed := 0, 0
+	for i, genre := range genres {
+		status := strconv.Itoa(statuses[i])
+		if errs[i] != nil {
+			failed++
+			status = errs[i].Error()
+			log.Printf("Error checking %s: %v", genre.Name, errs[i])
+		} else if !pageExists(statuses[i]) {
+			missing++
+		}
+		writer.Write([]string{genre.Name, genre.URL, status, strconv.FormatBool(errs[i] == nil && pageExists(statuses[i]))})
+	}
+	writer.Flush()
+	if err := writer.Error(); err != nil {
+		log.Printf("Error writing head check: %v", err)
+		return exitFatal
+	}
+
+	log.Printf("Checked %d genre pages: %d exist, %d missing, %d errors", len(genres), len(genres)-missing-failed, missing, failed)
+	if missing > 0 || failed > 0 {
+		return exitPartial
+	}
+	return exitOK
+}
+
+func pageExists(status int) bool {
+	return status >= 200 && status < 300
+}
+
+// checkPage returns the status of a HEAD request for url, falling back to a
+// GET when the server answers 405 or 501. Both requests wait for the limiter.
+func checkPage(ctx context.Context, url string) (int, error) {
+	status, err := requestStatus(ctx, http.MethodHead, url)
+	if err != nil || (status != http.StatusMethodNotAllowed && status != http.StatusNotImplemented) {
+		return status, err
+	}
+	return requestStatus(ctx, http.MethodGet, url)
+}
+
+func requestStatus(ctx context.Context, method, url string) (int, error) {
+	if err := limiter.Wait(ctx); err != nil {
+		return 0, err
+	}
+	req, err := http.NewRequestWithContext(ctx, method, url, nil)
+	if err != nil {
+		return 0, err
+	}
+	setAuth(req)
+	res, err := httpClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer res.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(res.Body, *maxBodyBytes))
+	if *verbose {
+		log.Printf("%s %s: %d", method, res.Request.URL, res.StatusCode)
+	}
+	return res.StatusCode, nil
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// onGenre callbacks are invoked for every genre the writer receives, after it
+// has been written to the output. They are called one at a time from the
+// writer goroutine in output order, so they need no locking of their own, but
+// a slow callback stalls the output.
+var onGenre []func(Genre)
+
+// genreCommand streams each genre as a line of JSON to the stdin of a
+// long-running command, e.g. one that pushes genres onto a queue.
+type genreCommand struct {
+	cmd   *exec.Cmd
+	stdin io.WriteCloser
+	enc   *json.Encoder
+	err   error
+}
+
+// startGenreCommand runs command with sh -c, so it can use quoting and pipes.
+// Its output goes to standard error along with the logs, since standard
+// output may be the --out - data stream.
+func startGenreCommand(command string) (*genreCommand, error) {
+	if strings.TrimSpace(command) == "" {
+		return nil, fmt.Errorf("empty command")
+	}
+
+	cmd := exec.Command("sh", "-c", command)
+	cmd.Stdout = os.Stderr
+	cmd.Stderr = os.Stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+
+	return &genreCommand{cmd: cmd, stdin: stdin, enc: json.NewEncoder(stdin)}, nil
+}
+
+// send writes genre to the command. After the first failure further genres
+// are dropped and the error is reported by wait.
+func (c *genreCommand) send(genre Genre) {
+	if c.err != nil {
+		return
+	}
+	c.err = c.enc.Encode(newJSONGenre(genre))
+}
+
+// wait closes the command's stdin and waits for it to exit.
+func (c *genreCommand) wait() error {
+	c.stdin.Close()
+	if err := c.cmd.Wait(); err != nil {
+		return err
+	}
+	return c.err
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+)
+
+// jsonGenre is the JSON representation of a Genre, shared by the json and
+// jsonl formats, the serve subcommand and --on-genre-cmd.
+//
+// A genre that was scraped has status "ok" and every list field is an array,
+// empty when the page genuinely had no entries. A genre that failed (only
+// written with --include-failed) has status "error", an error message, and
+// null list fields because nothing was parsed.
+type jsonGenre struct {
+	Name          string        `json:"name"`
+	CanonicalName string        `json:"canonical_name"`
+	Playlist      string        `json:"playlist"`
+	FontSize      string        `json:"font_size"`
+	ColorHex      string        `json:"color_hex"`
+	ColorRGB      string        `json:"color_rgb"`
+	Top           string        `json:"top"`
+	Left          string        `json:"left"`
+	ArtistWeights []weightValue `json:"artist_weights"`
+	Artists       []string      `json:"artists"`
+	SimWeights    []weightValue `json:"sim_weights"`
+	SimGenres     []string      `json:"sim_genres"`
+	OppWeights    []weightValue `json:"opp_weights"`
+	OppGenres     []string      `json:"opp_genres"`
+	Previews      []string      `json:"artist_previews,omitempty"`
+	MapLinks      []string      `json:"map_links,omitempty"`
+	MapLinkLabels []string      `json:"map_link_labels,omitempty"`
+	ArtistCount   int           `json:"artist_count_hint,omitempty"`
+	ScrapedAt     string        `json:"scraped_at,omitempty"`
+	ZIndex        *string       `json:"z_index,omitempty"`
+	Opacity       *string       `json:"opacity,omitempty"`
+	Status        string        `json:"status"`
+	Error         string        `json:"error,omitempty"`
+}
+
+func newJSONGenre(genre Genre) jsonGenre {
+	j := jsonGenre{
+		Name:          genre.Name,
+		CanonicalName: genre.CanonicalName,
+		Playlist:      genre.Playlist,
+		FontSize:      genre.FontSize,
+		ColorHex:      genre.ColorHex,
+		ColorRGB:      genre.ColorRGB,
+		Top:           genre.Top,
+		Left:          genre.Left,
+		ArtistCount:   genre.ArtistCountHint,
+		ScrapedAt:     genre.ScrapedAt,
+	}
+	if *styleExtras {
+		j.ZIndex = &genre.ZIndex
+		j.Opacity = &genre.Opacity
+	}
+	if genre.Error != "" {
+		j.Status = "error"
+		j.Error = genre.Error
+		return j
+	}
+
+	j.Status = "ok"
+	j.ArtistWeights = weightValues(nonNil(genre.ArtistWeights))
+	j.Artists = nonNil(genre.Artists)
+	j.SimWeights = weightValues(nonNil(genre.SimWeights))
+	j.SimGenres = nonNil(genre.SimGenres)
+	j.OppWeights = weightValues(nonNil(genre.OppWeights))
+	if *artistPreviews {
+		j.Previews = nonNil(genre.ArtistPreviews)
+	}
+	if *mapLinks {
+		j.MapLinks = nonNil(genre.MapLinks)
+		j.MapLinkLabels = nonNil(genre.MapLinkLabels)
+	}
+	j.OppGenres = nonNil(genre.OppGenres)
+	return j
+}
+
+// genre converts a decoded document back to a Genre, e.g. to merge outputs.
+func (j jsonGenre) genre() Genre {
+	genre := Genre{
+		Name:            j.Name,
+		CanonicalName:   j.CanonicalName,
+		Playlist:        j.Playlist,
+		FontSize:        j.FontSize,
+		ColorHex:        j.ColorHex,
+		ColorRGB:        j.ColorRGB,
+		Top:             j.Top,
+		Left:            j.Left,
+		ArtistWeights:   weightStrings(j.ArtistWeights),
+		Artists:         j.Artists,
+		SimWeights:      weightStrings(j.SimWeights),
+		SimGenres:       j.SimGenres,
+		OppWeights:      weightStrings(j.OppWeights),
+		OppGenres:       j.OppGenres,
+		ArtistPreviews:  j.Previews,
+		MapLinks:        j.MapLinks,
+		MapLinkLabels:   j.MapLinkLabels,
+		ArtistCountHint: j.ArtistCount,
+		ScrapedAt:       j.ScrapedAt,
+		Error:           j.Error,
+	}
+	if j.ZIndex != nil {
+		genre.ZIndex = *j.ZIndex
+	}
+	if j.Opacity != nil {
+		genre.Opacity = *j.Opacity
+	}
+	return genre
+}
+
+func nonNil(s []string) []string {
+	if s == nil {
+		return []string{}
+	}
+	return s
+}
+
+// weightValue is a weight written as a JSON number with --numeric-weights and
+// as a string otherwise. It reads either form back.
+type weightValue string
+
+func (w weightValue) MarshalJSON() ([]byte, error) {
+	if *numericWeights && isNumber(string(w)) {
+		return []byte(w), nil
+	}
+	return json.Marshal(string(w))
+}
+
+func (w *weightValue) UnmarshalJSON(data []byte) error {
+	if len(data) > 0 && data[0] == '"' {
+		var s string
+		err := json.Unmarshal(data, &s)
+		*w = weightValue(s)
+		return err
+	}
+	*w = weightValue(data)
+	return nil
+}
+
+// weightValues converts weights for encoding, keeping a nil slice nil.
+func weightValues(weights []string) []weightValue {
+	if weights == nil {
+		return nil
+	}
+	values := make([]weightValue, len(weights))
+	for i, w := range weights {
+		values[i] = weightValue(w)
+	}
+	return values
+}
+
+func weightStrings(values []weightValue) []string {
+	if values == nil {
+		return nil
+	}
+	weights := make([]string, len(values))
+	for i, v := range values {
+		weights[i] = string(v)
+	}
+	return weights
+}
+
+// jsonWriter writes genres as one JSON array (json) or one object per line
+// (jsonl).
+type jsonWriter struct {
+	out   io.Writer
+	buf   *bufio.Writer
+	lines bool
+	count int
+}
+
+func newJSONWriter(out io.Writer, lines bool) *jsonWriter {
+	w := &jsonWriter{out: out, buf: bufio.NewWriter(out), lines: lines}
+	if !lines {
+		w.buf.WriteString("[")
+	}
+	return w
+}
+
+func (w *jsonWriter) Write(genre Genre) error {
+	data, err := json.Marshal(newJSONGenre(genre))
+	if err != nil {
+		return err
+	}
+	if !w.lines {
+		if w.count > 0 {
+			w.buf.WriteString(",")
+		}
+		w.buf.WriteString("\n")
+	}
+	w.buf.Write(data)
+	if w.lines {
+		w.buf.WriteString("\n")
+	}
+	w.count++
+	return nil
+}
+
+func (w *jsonWriter) Flush() error {
+	if err := w.buf.Flush(); err != nil {
+		return err
+	}
+	return flushOutput(w.out)
+}
+
+func (w *jsonWriter) Close() error {
+	if !w.lines {
+		w.buf.WriteString("\n]\n")
+	}
+	if err := w.buf.Flush(); err != nil {
+		closeOutput(w.out)
+		return err
+	}
+	return closeOutput(w.out)
+}
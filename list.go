@@ -0,0 +1,104 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// listGenre is a genre as it appears on the main map, without anything from
+// its own page.
+type listGenre struct {
+	Name     string  `json:"name"`
+	Playlist string  `json:"playlist"`
+	FontSize string  `json:"font_size"`
+	ColorHex string  `json:"color_hex"`
+	ColorRGB string  `json:"color_rgb"`
+	Top      string  `json:"top"`
+	Left     string  `json:"left"`
+	ZIndex   *string `json:"z_index,omitempty"`
+	Opacity  *string `json:"opacity,omitempty"`
+}
+
+// isListFormat reports whether path has an extension writeGenreList knows.
+func isListFormat(path string) bool {
+	switch filepath.Ext(path) {
+	case ".csv", ".json", ".jsonl":
+		return true
+	}
+	return false
+}
+
+// writeGenreList saves the map fields of the genre list to path, as CSV for
+// .csv, a JSON array for .json and one object per line for .jsonl.
+func writeGenreList(path string, genres []Genre) error {
+	if !isListFormat(path) {
+		return fmt.Errorf("cannot tell the list format of %s; use .csv, .json or .jsonl", path)
+	}
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+
+	switch filepath.Ext(path) {
+	case ".json", ".jsonl":
+		err = writeGenreListJSON(file, genres, filepath.Ext(path) == ".jsonl")
+	case ".csv":
+		err = writeGenreListCSV(file, genres)
+	}
+	if err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+func writeGenreListJSON(file *os.File, genres []Genre, lines bool) error {
+	docs := make([]listGenre, len(genres))
+	for i, genre := range genres {
+		docs[i] = listGenre{
+			Name:     genre.Name,
+			Playlist: genre.Playlist,
+			FontSize: genre.FontSize,
+			ColorHex: genre.ColorHex,
+			ColorRGB: genre.ColorRGB,
+			Top:      genre.Top,
+			Left:     genre.Left,
+		}
+		if *styleExtras {
+			docs[i].ZIndex = &genres[i].ZIndex
+			docs[i].Opacity = &genres[i].Opacity
+		}
+	}
+
+	enc := json.NewEncoder(file)
+	if !lines {
+		return enc.Encode(docs)
+	}
+	for _, doc := range docs {
+		if err := enc.Encode(doc); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeGenreListCSV(file *os.File, genres []Genre) error {
+	writer := csv.NewWriter(file)
+	header := []string{"Genre", "Playlist", "FontSize", "ColorHex", "ColorRGB", "Top", "Left"}
+	if *styleExtras {
+		header = append(header, "ZIndex", "Opacity")
+	}
+	writer.Write(header)
+	for _, genre := range genres {
+		row := []string{genre.Name, genre.Playlist, genre.FontSize, genre.ColorHex, genre.ColorRGB, genre.Top, genre.Left}
+		if *styleExtras {
+			row = append(row, genre.ZIndex, genre.Opacity)
+		}
+		writer.Write(row)
+	}
+	writer.Flush()
+	return writer.Error()
+}
@@ -1,8 +1,11 @@
 package main
 
 import (
+	"bytes"
+	"compress/gzip"
 	"context"
-	"encoding/csv"
+	"errors"
+	"flag"
 	"fmt"
 	"github.com/PuerkitoBio/goquery"
 	"golang.org/x/sync/errgroup"
@@ -12,7 +15,7 @@ import (
 	"net/url"
 	"os"
 	"regexp"
-	"runtime"
+	"strconv"
 	"strings"
 	"sync"
 	"sync/atomic"
@@ -21,6 +24,7 @@ import (
 
 type Genre struct {
 	Name          string
+	CanonicalName string
 	Playlist      string
 	FontSize      string
 	ColorHex      string
@@ -33,41 +37,325 @@ type Genre struct {
 	SimGenres     []string
 	OppWeights    []string
 	OppGenres     []string
+
+	// ZIndex and Opacity are only written with --style-extras.
+	ZIndex  string
+	Opacity string
+
+	// ArtistPreviews is aligned with Artists and holds each artist's Spotify
+	// URI or preview URL, or "" when it has none. Only set with
+	// --artist-previews.
+	ArtistPreviews []string
+
+	// MapLinks are links from a genre page to other everynoise maps and
+	// category pages, e.g. broader maps, outside the similar and opposite
+	// genres. MapLinkLabels holds each link's text. Only set with --map-links.
+	MapLinks      []string
+	MapLinkLabels []string
+
+	// ArtistCountHint is the artist count some genre pages state in their
+	// header, or 0 when the page has none.
+	ArtistCountHint int
+
+	// ScrapedAt is when the genre page was fetched, in RFC 3339 UTC. merge
+	// --merge-keep newest compares it.
+	ScrapedAt string
+
+	// URL overrides the genre page URL normally built from Name.
+	URL string
+
+	// Error is set on genres that failed to scrape; these are only sent to
+	// the writer with --include-failed.
+	Error string
 }
 
 var (
 	limiter    = rate.NewLimiter(rate.Every(50*time.Millisecond), 1)
 	httpClient = &http.Client{
-		Timeout: 10 * time.Second,
-		Transport: &http.Transport{
-			MaxIdleConns:        100,
-			MaxIdleConnsPerHost: 100,
-			IdleConnTimeout:     90 * time.Second,
-		},
+		Timeout:   10 * time.Second,
+		Transport: &http.Transport{}, // tuned from flags by configureTransport
 	}
 )
 
 const batchSize = 250
 
+// defaultWorkers is independent of GOMAXPROCS: scraping waits on the network,
+// not the CPU, so a single-CPU container still benefits from concurrency.
+const defaultWorkers = 8
+
+var (
+	noSharedWeights = flag.Bool("no-shared-weights", false, "record each genre page's own artist weights instead of the first weight seen for that artist")
+	outputFormat    = flag.String("format", "csv", "output format: csv, json, jsonl, parquet, ndjson, dot or graphml")
+	outputPaths     = outputFlag("out", "output path, or - for stdout (default genres.<format>, or genres-ndjson/ for ndjson); repeat to write several outputs, each in the format of its extension")
+	keepDuplicates  = flag.Bool("keep-duplicates", false, "scrape every genre list entry even if its name repeats")
+	maxBodyBytes    = flag.Int64("max-body-bytes", 8<<20, "maximum response body size in bytes; larger pages fail")
+	insecureTLS     = flag.Bool("insecure", false, "skip TLS certificate verification (unsafe)")
+	caCertFile      = flag.String("ca-cert", "", "PEM file of extra CA certificates to trust")
+	cacheDir        = flag.String("cache-dir", "", "directory for cached pages, revalidated with conditional GETs")
+	onGenreCmd      = flag.String("on-genre-cmd", "", "command that receives each scraped genre as a line of JSON on stdin")
+	linkMarker      = flag.String("marker", "»", "link marker stripped from genre and artist names (empty to keep text as-is)")
+	maxIdleConns    = flag.Int("max-idle-conns", 100, "maximum idle HTTP connections across all hosts")
+	maxIdlePerHost  = flag.Int("max-idle-conns-per-host", 100, "maximum idle HTTP connections per host")
+	maxConnsPerHost = flag.Int("max-conns-per-host", 0, "maximum HTTP connections per host (0 for no limit)")
+	idleConnTimeout = flag.Duration("idle-conn-timeout", 90*time.Second, "how long an idle HTTP connection is kept open")
+	forceHTTP2      = flag.Bool("http2", false, "attempt HTTP/2 on the custom transport")
+	sortKey         = flag.String("sort", "", "buffer all results and write them sorted by name, position or artists")
+	deterministic   = flag.Bool("deterministic", false, "produce byte-identical output for identical pages (implies --no-shared-weights and --sort name)")
+	maxFailures     = flag.Int("max-consecutive-failures", 25, "abort the run after this many genres fail in a row (0 to never abort)")
+	includeFailed   = flag.Bool("include-failed", false, "write genres that failed to scrape, with status and error fields (json and jsonl only)")
+	rateInterval    = flag.Duration("rate-interval", 50*time.Millisecond, "minimum time between requests")
+	workerCount     = flag.Int("workers", defaultWorkers, "number of genres scraped concurrently")
+	workersAuto     = flag.Bool("workers-auto", false, "derive the worker count from --rate-interval instead of --workers")
+	urlsFile        = flag.String("urls-file", "", "scrape the genre page URLs listed in this file instead of the genre list")
+	gzipOutput      = flag.Bool("gzip", false, "gzip-compress output files (adds .gz to the path)")
+	gzipLevel       = flag.Int("gzip-level", gzip.DefaultCompression, "gzip compression level: -2 (Huffman only), -1 (default, 6), 0 (none) to 9 (best)")
+	includeArtists  = flag.String("include-artists", "", "only keep these artists: comma-separated names or @file with one per line")
+	excludeArtists  = flag.String("exclude-artists", "", "drop these artists: comma-separated names or @file with one per line")
+	fuzzyArtists    = flag.Bool("fuzzy-artists", false, "match artist filters ignoring spaces and punctuation and allowing one-character typos")
+	appendOutput    = flag.Bool("append", false, "append to the output instead of replacing it (csv, jsonl and ndjson)")
+	onlyMissing     = flag.Bool("only-missing", false, "only scrape genres on the map that are not yet in the --out file, appending them (implies --append)")
+	verbose         = flag.Bool("verbose", false, "log the final URL, status, size and duration of every request")
+	fsyncOutput     = flag.Bool("fsync", false, "sync output files to disk after every batch")
+	styleExtras     = flag.Bool("style-extras", false, "also write each genre's z-index and opacity from the map")
+	maxRetries      = flag.Int("retries", 2, "retries for a page after a network error or 429/5xx response")
+	retryBackoff    = flag.Duration("retry-backoff", time.Second, "delay before the first retry, doubled for each further retry")
+	headCheck       = flag.Bool("head-check", false, "only check which genre pages exist with HEAD requests, writing a CSV report to stdout")
+	paletteOut      = flag.String("palette", "", "write a JSON summary of the map colors and the genres using each to this file")
+	reportHTML      = flag.String("report-html", "", "write a self-contained HTML map of the scraped genres to this file")
+	weightUnit      = flag.String("weight-unit", "percent", "unit font sizes and weights are normalized to: percent or px")
+	validateChecks  = flag.String("validate", "", "comma-separated checks to run on the scraped genres, or all: min-genres, color, position, playlist, artists, weights")
+	validateMin     = flag.Int("validate-min-genres", 1000, "fewest genres the min-genres check accepts")
+	flushInterval   = flag.Duration("flush-interval", 30*time.Second, "also flush pending genres at least this often; 0 flushes only every batch")
+	genreTimeout    = flag.Duration("genre-timeout", 2*time.Minute, "give up on a genre after this long, including retries and rate limiting; 0 for no limit")
+	retryEmpty      = flag.Int("retry-empty", 0, "fetch a genre page that parses to no artists up to this many more times before accepting it")
+	mergeKeep       = flag.String("merge-keep", "first", "which copy of a repeated genre the merge subcommand keeps: first, last or newest")
+	basicAuth       = flag.String("basic-auth", "", "user:password sent as HTTP basic auth with every request, or @file to read it from")
+	bearerToken     = flag.String("bearer-token", "", "token sent as a bearer Authorization header with every request, or @file to read it from")
+	numericWeights  = flag.Bool("numeric-weights", false, "write weights that aren't numbers as 0, and weights as JSON numbers rather than strings")
+	throttleRegexp  = flag.String("throttle-pattern", "", "regexp matching a page body that means the site is throttling, e.g. (?i)too many requests|try again later")
+	shardSpec       = flag.String("shard", "", "only scrape shard i/n of the genres, numbered from 0, e.g. 0/4")
+	artistPreviews  = flag.Bool("artist-previews", false, "also write each artist's Spotify URI or preview URL, aligned with Artists")
+	warmup          = flag.Duration("warmup", 0, "start at a tenth of the request rate and ramp up to it linearly over this long")
+	mapLinks        = flag.Bool("map-links", false, "also write each genre page's links to other everynoise maps and category pages")
+	cacheGzip       = flag.Bool("cache-gzip", true, "gzip pages stored in --cache-dir")
+	coverageOut     = flag.String("coverage-out", "", "write a JSON report of how many genres were scraped, failed or missing to this file")
+	minArtistWeight = flag.Float64("min-artist-weight", 0, "drop artists whose weight is below this, in --weight-unit")
+	minGenreWeight  = flag.Float64("min-genre-weight", 0, "drop similar and opposite genres whose weight is below this, in --weight-unit")
+	listOut         = flag.String("list-out", "", "also save the genre list from the map (name, playlist, color, position, font size) to this .csv, .json or .jsonl file")
+	listOnly        = flag.Bool("list-only", false, "stop after writing --list-out, without scraping genre pages")
+	listenAddr      = flag.String("addr", ":8080", "listen address for the serve subcommand")
+)
+
+// Exit codes. A non-zero code is only returned once the output has been
+// flushed and closed.
+const (
+	exitOK      = 0 // every genre was scraped and written
+	exitPartial = 1 // the run completed but some genres failed
+	exitFatal   = 2 // bad flags, setup failure, no genre list, or the run was aborted
+	exitInvalid = 3 // the run completed but a --validate check failed
+)
+
 func main() {
+	os.Exit(run())
+}
+
+func run() int {
+	if len(os.Args) > 1 && os.Args[1] == "serve" {
+		flag.CommandLine.Parse(os.Args[2:])
+		if err := setupFetch(); err != nil {
+			log.Printf("Error configuring HTTP client: %v", err)
+			return exitFatal
+		}
+		log.Print(serve(*listenAddr))
+		return exitFatal
+	}
+	if len(os.Args) > 1 && os.Args[1] == "merge" {
+		flag.CommandLine.Parse(os.Args[2:])
+		if err := validateFlags(); err != nil {
+			log.Print(err)
+			return exitFatal
+		}
+		if flag.NArg() == 0 {
+			log.Print("merge needs at least one input file")
+			return exitFatal
+		}
+		if err := mergeOutputs(flag.Args()); err != nil {
+			log.Print(err)
+			return exitFatal
+		}
+		return exitOK
+	}
+
+	flag.Parse()
+	if err := validateFlags(); err != nil {
+		log.Print(err)
+		return exitFatal
+	}
+	if err := setupFetch(); err != nil {
+		log.Printf("Error configuring HTTP client: %v", err)
+		return exitFatal
+	}
+
+	var hookCmd *genreCommand
+	if *onGenreCmd != "" {
+		cmd, err := startGenreCommand(*onGenreCmd)
+		if err != nil {
+			log.Printf("Error starting --on-genre-cmd: %v", err)
+			return exitFatal
+		}
+		hookCmd = cmd
+		onGenre = append(onGenre, cmd.send)
+	}
+
+	var palette *paletteCollector
+	if *paletteOut != "" {
+		palette = newPaletteCollector()
+		onGenre = append(onGenre, palette.add)
+	}
+
+	var report *reportCollector
+	if *reportHTML != "" {
+		report = &reportCollector{}
+		onGenre = append(onGenre, report.add)
+	}
+
+	var checks *validator
+	if *validateChecks != "" {
+		v, err := newValidator(*validateChecks, *validateMin)
+		if err != nil {
+			log.Print(err)
+			return exitFatal
+		}
+		checks = v
+		onGenre = append(onGenre, checks.add)
+	}
+
 	start := time.Now()
 	log.Println("Starting the scraping process...")
 
-	genres := scrapeGenreList()
+	var genres []Genre
+	if *urlsFile != "" {
+		list, err := readGenreURLs(*urlsFile)
+		if err != nil {
+			log.Printf("Error reading --urls-file: %v", err)
+			return exitFatal
+		}
+		genres = list
+	} else {
+		list, err := scrapeGenreList()
+		if err != nil {
+			log.Print(err)
+			return exitFatal
+		}
+		genres = list
+
+		if *listOut != "" {
+			if err := writeGenreList(*listOut, genres); err != nil {
+				log.Printf("Error writing genre list: %v", err)
+				return exitFatal
+			}
+			log.Printf("Wrote the %d genres on the map to %s", len(genres), *listOut)
+			if *listOnly {
+				if hookCmd != nil {
+					hookCmd.wait()
+				}
+				return exitOK
+			}
+		}
+	}
+	if *shardSpec != "" {
+		i, n, _ := parseShard(*shardSpec)
+		all := len(genres)
+		genres = shardGenres(genres, i, n)
+		log.Printf("Shard %d/%d has %d of %d genres", i, n, len(genres), all)
+	}
+	listDone := time.Now()
 	totalGenres := len(genres)
 	log.Printf("Found %d genres to process", totalGenres)
 
+	alreadyScraped := 0
+	if *onlyMissing {
+		path := outputs[0].path
+		scraped, err := readScrapedNames(path)
+		if err != nil {
+			log.Printf("Error reading %s: %v", path, err)
+			return exitFatal
+		}
+		missing := missingGenres(genres, scraped)
+		alreadyScraped = len(genres) - len(missing)
+		log.Printf("%d genres already in %s, %d missing", alreadyScraped, path, len(missing))
+		genres = missing
+	}
+
+	workers := *workerCount
+	if *workersAuto {
+		workers = autoWorkers(*rateInterval)
+		log.Printf("Using %d workers for one request every %v", workers, *rateInterval)
+	}
+	if workers < 1 {
+		log.Printf("--workers must be at least 1")
+		return exitFatal
+	}
+
+	if *headCheck {
+		return headCheckGenres(genres, workers)
+	}
+
+	// Open the output before dispatching any work, so an unwritable path
+	// fails the run before anything is fetched
+	writer, err := newOutputWriter()
+	if err != nil {
+		log.Printf("Cannot create output: %v", err)
+		return exitFatal
+	}
+
+	// Drop entries whose page is already queued before counting genres, so
+	// that duplicates can't hold progress and coverage below 100%
+	visited := newVisitedSet()
+	skipped := 0
+	queued := make([]Genre, 0, len(genres))
+	for _, genre := range genres {
+		if genre.URL == "" {
+			genre.URL = genreURL(everynoiseBase, genre.Name)
+		}
+		if !*keepDuplicates && !visited.add(genre.URL) {
+			skipped++
+			continue
+		}
+		queued = append(queued, genre)
+	}
+	genres = queued
+	if skipped > 0 {
+		log.Printf("Skipped %d genres whose page was already queued", skipped)
+	}
+
 	results := make(chan Genre, batchSize)
 	g, ctx := errgroup.WithContext(context.Background())
-
-	workers := runtime.GOMAXPROCS(0)
 	semaphore := make(chan struct{}, workers)
 
-	var processedCount int32
+	prog := newProgress(totalGenres-skipped, alreadyScraped)
+	totalGenres = len(genres)
+	breaker := &circuitBreaker{limit: int32(*maxFailures)}
+
+	// SIGUSR1 logs progress and flushes the writer without stopping the run
+	flushRequests := make(chan struct{}, 1)
+	stopFlushSignal := notifyFlushSignal(func() {
+		prog.log()
+		select {
+		case flushRequests <- struct{}{}:
+		default:
+		}
+	})
+	defer stopFlushSignal()
+
+	if *warmup > 0 {
+		log.Printf("Ramping up to one request every %v over %v", *rateInterval, *warmup)
+		defer rampUp(*warmup)()
+	}
 
-	// Start the CSV writer
-	csvDone := make(chan struct{})
-	go writeResultsToCSV(results, csvDone, totalGenres)
+	// Start the output writer
+	writeDone := make(chan struct{})
+	go writeResults(writer, results, flushRequests, writeDone, totalGenres)
 
 	for _, genre := range genres {
 		genre := genre // https://golang.org/doc/faq#closures_and_goroutines
@@ -79,18 +367,53 @@ func main() {
 				return ctx.Err()
 			}
 
-			if err := limiter.Wait(ctx); err != nil {
-				return fmt.Errorf("rate limiter error for %s: %v", genre.Name, err)
+			genreCtx, cancel := ctx, context.CancelFunc(func() {})
+			if *genreTimeout > 0 {
+				genreCtx, cancel = context.WithTimeout(ctx, *genreTimeout)
 			}
-
-			genreData, err := scrapeGenreData(ctx, genre.Name)
+			genreData, err := scrapeGenrePage(genreCtx, genre.Name, genre.URL)
+			timedOut := genreCtx.Err() != nil
+			cancel()
 			if err != nil {
-				return fmt.Errorf("error scraping %s: %v", genre.Name, err)
+				if ctx.Err() != nil {
+					return ctx.Err()
+				}
+				if timedOut {
+					err = fmt.Errorf("timed out after %v: %w", *genreTimeout, err)
+				}
+				if errors.Is(err, errPageMissing) {
+					// The site answered, so this doesn't count towards the breaker
+					prog.miss(genre.Name)
+					breaker.success()
+					log.Printf("No data for %s: %v", genre.Name, err)
+				} else {
+					prog.fail()
+					log.Printf("Error scraping %s: %v", genre.Name, err)
+					if breaker.failure() {
+						return fmt.Errorf("%d genres failed in a row, everynoise appears to be down", breaker.limit)
+					}
+				}
+				if *includeFailed {
+					genre.Error = err.Error()
+					select {
+					case results <- genre:
+					case <-ctx.Done():
+						return ctx.Err()
+					}
+				}
+				return nil
 			}
+			breaker.success()
 
+			genre.CanonicalName = genreData.CanonicalName
+			genre.ArtistCountHint = genreData.ArtistCountHint
+			genre.ScrapedAt = genreData.ScrapedAt
 			genre.Playlist = genreData.Playlist
 			genre.ArtistWeights = genreData.ArtistWeights
 			genre.Artists = genreData.Artists
+			genre.ArtistPreviews = genreData.ArtistPreviews
+			genre.MapLinks = genreData.MapLinks
+			genre.MapLinkLabels = genreData.MapLinkLabels
 			genre.SimWeights = genreData.SimWeights
 			genre.SimGenres = genreData.SimGenres
 			genre.OppWeights = genreData.OppWeights
@@ -98,10 +421,7 @@ func main() {
 
 			select {
 			case results <- genre:
-				atomic.AddInt32(&processedCount, 1)
-				if processed := atomic.LoadInt32(&processedCount); processed%100 == 0 || processed == int32(totalGenres) {
-					log.Printf("Processed %d/%d genres", processed, totalGenres)
-				}
+				prog.complete()
 			case <-ctx.Done():
 				return ctx.Err()
 			}
@@ -110,219 +430,463 @@ func main() {
 		})
 	}
 
+	code := exitOK
 	if err := g.Wait(); err != nil {
 		log.Printf("Error during scraping: %v", err)
+		code = exitFatal
+	}
+	if failed := prog.failures(); failed > 0 {
+		log.Printf("%d/%d genres failed to scrape", failed, totalGenres)
+		code = max(code, exitPartial)
+	}
+	missing := prog.missingPages()
+	if len(missing) > 0 {
+		log.Printf("%d genres are listed on the map but have no genre page: %s", len(missing), strings.Join(missing, ", "))
 	}
 
+	scrapeDone := time.Now()
 	close(results)
-	<-csvDone // Wait for CSV writing to complete
+	<-writeDone // Wait for output writing to complete
+	outputDone := time.Now()
 
-	log.Printf("Scraping completed in %v", time.Since(start))
-}
+	if hookCmd != nil {
+		if err := hookCmd.wait(); err != nil {
+			log.Printf("Error from --on-genre-cmd: %v", err)
+			code = max(code, exitPartial)
+		}
+	}
 
-func writeResultsToCSV(results <-chan Genre, done chan<- struct{}, totalGenres int) {
-	defer close(done)
+	if palette != nil {
+		if err := palette.write(*paletteOut); err != nil {
+			log.Printf("Error writing palette: %v", err)
+			code = max(code, exitPartial)
+		} else {
+			log.Printf("Wrote %d colors to %s", len(palette.colors), *paletteOut)
+		}
+	}
 
-	file, err := os.Create("genres.csv")
-	if err != nil {
-		log.Fatalf("Cannot create file: %v", err)
-	}
-	defer file.Close()
-
-	writer := csv.NewWriter(file)
-	defer writer.Flush()
-
-	headers := []string{"Genre", "Playlist", "FontSize", "ColorHex", "ColorRGB", "Top", "Left", "ArtistWeights", "Artists", "SimWeights", "SimGenres", "OppWeights", "OppGenres"}
-	if err := writer.Write(headers); err != nil {
-		log.Fatalf("Error writing headers: %v", err)
-	}
-
-	var batch [][]string
-	genreCount := 0
-
-	for genre := range results {
-		row := []string{
-			genre.Name,
-			genre.Playlist,
-			genre.FontSize,
-			genre.ColorHex,
-			genre.ColorRGB,
-			genre.Top,
-			genre.Left,
-			strings.Join(genre.ArtistWeights, "|"),
-			strings.Join(genre.Artists, "|"),
-			strings.Join(genre.SimWeights, "|"),
-			strings.Join(genre.SimGenres, "|"),
-			strings.Join(genre.OppWeights, "|"),
-			strings.Join(genre.OppGenres, "|"),
-		}
-		batch = append(batch, row)
-		genreCount++
-
-		if len(batch) >= batchSize {
-			if err := writer.WriteAll(batch); err != nil {
-				log.Printf("Error writing batch: %v", err)
-			}
-			writer.Flush()
-			log.Printf("Wrote batch of %d genres. Total written: %d/%d", len(batch), genreCount, totalGenres)
-			batch = batch[:0] // Clear the batch
+	if report != nil {
+		report.missing = missing
+		if err := report.write(*reportHTML); err != nil {
+			log.Printf("Error writing HTML report: %v", err)
+			code = max(code, exitPartial)
+		} else {
+			log.Printf("Wrote HTML report of %d genres to %s", len(report.points), *reportHTML)
 		}
 	}
 
-	// Write any remaining genres
-	if len(batch) > 0 {
-		if err := writer.WriteAll(batch); err != nil {
-			log.Printf("Error writing final batch: %v", err)
+	if *coverageOut != "" {
+		coverage := newCoverageReport(prog, skipped)
+		if err := coverage.write(*coverageOut); err != nil {
+			log.Printf("Error writing coverage report: %v", err)
+			code = max(code, exitPartial)
+		} else {
+			log.Printf("Covered %.2f%% of %d genres, written to %s", coverage.Coverage, coverage.Genres, *coverageOut)
 		}
-		writer.Flush()
-		log.Printf("Wrote final batch of %d genres. Total written: %d/%d", len(batch), genreCount, totalGenres)
 	}
 
-	log.Printf("Successfully wrote %d/%d genres to CSV", genreCount, totalGenres)
+	if checks != nil && !checks.report() && code != exitFatal {
+		code = exitInvalid
+	}
+
+	if n := atomic.LoadInt32(&malformedWeights); n > 0 {
+		log.Printf("%d weights were not numbers and were written as 0", n)
+	}
+	if cache != nil {
+		log.Printf("%d pages unchanged since they were cached", atomic.LoadInt32(&notModifiedHits))
+	}
+	logTimings([]phase{
+		{"genre list", listDone.Sub(start)},
+		{"scraping", scrapeDone.Sub(listDone)},
+		{"finishing output", outputDone.Sub(scrapeDone)},
+		{"reports", time.Since(outputDone)},
+	}, workers)
+	log.Printf("Scraping completed in %v", time.Since(start))
+	return code
 }
 
-func scrapeGenreList() []Genre {
-	res, err := httpClient.Get("https://everynoise.com/engenremap.html")
+// validateFlags rejects flag combinations that can't work and fills in
+// values implied by other flags.
+func validateFlags() error {
+	if !isOutputFormat(*outputFormat) {
+		return fmt.Errorf("unknown output format %q", *outputFormat)
+	}
+	if err := resolveOutputs(); err != nil {
+		return err
+	}
+	if *onlyMissing {
+		*appendOutput = true
+		if *urlsFile != "" {
+			return fmt.Errorf("--only-missing compares against the genre list and cannot be used with --urls-file")
+		}
+		if len(outputs) != 1 || outputs[0].format != "csv" {
+			return fmt.Errorf("--only-missing reads existing genres from a single csv --out file")
+		}
+	}
+	if *appendOutput && *gzipOutput {
+		return fmt.Errorf("--append cannot be used with --gzip")
+	}
+	for _, target := range outputs {
+		if err := checkOutput(target); err != nil {
+			return err
+		}
+	}
+	if *gzipLevel < gzip.HuffmanOnly || *gzipLevel > gzip.BestCompression {
+		return fmt.Errorf("--gzip-level must be between %d and %d", gzip.HuffmanOnly, gzip.BestCompression)
+	}
+	if *deterministic {
+		// The shared weight cache keeps whichever weight was fetched first,
+		// which depends on scheduling.
+		*noSharedWeights = true
+		if *sortKey == "" {
+			*sortKey = "name"
+		}
+	}
+	if *weightUnit != "percent" && *weightUnit != "px" {
+		return fmt.Errorf("--weight-unit must be percent or px")
+	}
+	if *listOnly && *listOut == "" {
+		return fmt.Errorf("--list-only needs --list-out")
+	}
+	if *listOut != "" && *urlsFile != "" {
+		return fmt.Errorf("--list-out saves the genre list from the map and cannot be used with --urls-file")
+	}
+	if *listOut != "" && !isListFormat(*listOut) {
+		return fmt.Errorf("--list-out must be a .csv, .json or .jsonl file, not %s", *listOut)
+	}
+	if *warmup > 0 && *rateInterval <= 0 {
+		return fmt.Errorf("--warmup needs a --rate-interval to ramp up to")
+	}
+	if *shardSpec != "" {
+		if _, _, err := parseShard(*shardSpec); err != nil {
+			return err
+		}
+	}
+	if !isMergeKeep(*mergeKeep) {
+		return fmt.Errorf("--merge-keep must be first, last or newest")
+	}
+	if err := validateSortKey(*sortKey); err != nil {
+		return err
+	}
+	return nil
+}
+
+// checkOutput rejects an output target that can't be written with the other
+// flags.
+func checkOutput(target outputTarget) error {
+	if *includeFailed && target.format != "json" && target.format != "jsonl" {
+		return fmt.Errorf("--include-failed requires json or jsonl output, but %s is %s", target.path, target.format)
+	}
+	if target.path == stdoutPath && *appendOutput {
+		return fmt.Errorf("--append and --only-missing need an --out file, not stdout")
+	}
+	if remoteScheme(target.path) != "" {
+		if *appendOutput {
+			return fmt.Errorf("--append and --only-missing need a local --out file")
+		}
+		if target.format == "ndjson" {
+			return fmt.Errorf("ndjson writes a directory and cannot be uploaded; use --format jsonl")
+		}
+	}
+	if *appendOutput {
+		if err := checkAppendable(target.format); err != nil {
+			return err
+		}
+	}
+	if *gzipOutput && target.format == "parquet" {
+		return fmt.Errorf("--gzip cannot be used with parquet, which compresses its own columns")
+	}
+	if err := checkWritable(target); err != nil {
+		return fmt.Errorf("cannot write %s: %v", target.path, err)
+	}
+	return nil
+}
+
+func scrapeGenreList() ([]Genre, error) {
+	body, err := fetchPage(context.Background(), everynoiseBase+"/engenremap.html")
 	if err != nil {
-		log.Fatalf("Error fetching genre list: %v", err)
+		return nil, fmt.Errorf("error fetching genre list: %v", err)
 	}
-	defer res.Body.Close()
 
-	doc, err := goquery.NewDocumentFromReader(res.Body)
+	doc, err := goquery.NewDocumentFromReader(bytes.NewReader(body))
 	if err != nil {
-		log.Fatalf("Error parsing genre list: %v", err)
+		return nil, fmt.Errorf("error parsing genre list: %v", err)
+	}
+
+	genres := parseGenreList(doc)
+	if !*keepDuplicates {
+		var dropped int
+		genres, dropped = dedupGenres(genres)
+		if dropped > 0 {
+			log.Printf("Warning: dropped %d duplicate genres from the genre list", dropped)
+		}
 	}
+	return genres, nil
+}
 
+func parseGenreList(doc *goquery.Document) []Genre {
 	var genres []Genre
 	doc.Find("div.genre.scanme").Each(func(i int, s *goquery.Selection) {
-		genreName := strings.TrimSpace(s.Text())
-		genreName = strings.TrimSuffix(genreName, "»")
+		genreName := normalizeGenreName(s.Text())
 		playlist, _ := s.Find("a").Attr("href")
 		style, _ := s.Attr("style")
-		fontSize, colorHex, colorRGB, top, left := extractStyleAttributes(style)
+		attrs := styles.attributes(style)
 		genres = append(genres, Genre{
 			Name:     genreName,
 			Playlist: playlist,
-			FontSize: fontSize,
-			ColorHex: colorHex,
-			ColorRGB: colorRGB,
-			Top:      top,
-			Left:     left,
+			FontSize: attrs.FontSize,
+			ColorHex: attrs.ColorHex,
+			ColorRGB: attrs.ColorRGB,
+			Top:      attrs.Top,
+			Left:     attrs.Left,
+			ZIndex:   attrs.ZIndex,
+			Opacity:  attrs.Opacity,
 		})
 	})
 
 	return genres
 }
 
-var (
-	fontSizeRe = regexp.MustCompile(`font-size:([^;]+)`)
-	colorRe    = regexp.MustCompile(`color:([^;]+)`)
-	topRe      = regexp.MustCompile(`top:([^;]+)`)
-	leftRe     = regexp.MustCompile(`left:([^;]+)`)
-)
-
-func extractStyleAttributes(style string) (fontSize, colorHex, colorRGB, top, left string) {
-	if match := fontSizeRe.FindStringSubmatch(style); len(match) > 1 {
-		fontSize = strings.TrimSpace(match[1])
-	}
-	if match := colorRe.FindStringSubmatch(style); len(match) > 1 {
-		colorHex = strings.TrimSpace(match[1])
-		r, g, b := hexToRGB(colorHex)
-		colorRGB = fmt.Sprintf("rgb(%d, %d, %d)", r, g, b)
-	}
-	if match := topRe.FindStringSubmatch(style); len(match) > 1 {
-		top = strings.TrimSpace(match[1])
-	}
-	if match := leftRe.FindStringSubmatch(style); len(match) > 1 {
-		left = strings.TrimSpace(match[1])
+// dedupGenres keeps the first genre for each name and reports how many later
+// entries with the same name were dropped.
+func dedupGenres(genres []Genre) ([]Genre, int) {
+	seen := make(map[string]bool, len(genres))
+	unique := genres[:0]
+	for _, genre := range genres {
+		if seen[genre.Name] {
+			continue
+		}
+		seen[genre.Name] = true
+		unique = append(unique, genre)
 	}
-	return
+	return unique, len(genres) - len(unique)
+}
+
+// visitedSet records the genre pages dispatched in this run so that each page
+// is fetched at most once, even when differently named inputs map to the same
+// URL. It is safe for concurrent use.
+type visitedSet struct {
+	mu   sync.Mutex
+	seen map[string]bool
 }
 
-func hexToRGB(hex string) (r, g, b int) {
-	fmt.Sscanf(hex, "#%02x%02x%02x", &r, &g, &b)
-	return
+func newVisitedSet() *visitedSet {
+	return &visitedSet{seen: make(map[string]bool)}
 }
 
+// add marks key as visited, reporting false if it already was.
+func (v *visitedSet) add(key string) bool {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if v.seen[key] {
+		return false
+	}
+	v.seen[key] = true
+	return true
+}
+
+// artistsWeights remembers the first weight seen for each artist so that an
+// artist carries the same weight in every genre row. This is the default to
+// keep ArtistWeights comparable across genres; --no-shared-weights bypasses it.
 var (
 	artistWeightsMu sync.Mutex
 	artistsWeights  = make(map[string]string)
 )
 
 func scrapeGenreData(ctx context.Context, genre string) (Genre, error) {
-	encodedGenre := url.QueryEscape(strings.ReplaceAll(genre, " ", ""))
-	url := fmt.Sprintf("https://everynoise.com/engenremap-%s.html", encodedGenre)
+	return scrapeGenrePage(ctx, genre, genreURL(everynoiseBase, genre))
+}
 
-	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
-	if err != nil {
-		return Genre{}, fmt.Errorf("error creating request for %s: %v", genre, err)
-	}
+// scrapeGenrePage fetches and parses the genre page at url. With
+// --retry-empty, a page that parses to no artists is fetched again, bypassing
+// the cache, before the empty result is accepted.
+func scrapeGenrePage(ctx context.Context, genre, url string) (Genre, error) {
+	var data Genre
+	for attempt := 0; ; attempt++ {
+		body, err := fetchPage(ctx, url)
+		if err != nil {
+			return Genre{}, fmt.Errorf("error fetching %s: %w", genre, err)
+		}
 
-	res, err := httpClient.Do(req)
-	if err != nil {
-		return Genre{}, fmt.Errorf("error fetching %s: %v", genre, err)
+		parseStart := time.Now()
+		doc, err := goquery.NewDocumentFromReader(bytes.NewReader(body))
+		if err != nil {
+			return Genre{}, fmt.Errorf("error parsing %s: %w: %v", genre, errParseFailed, err)
+		}
+
+		data = parseGenrePage(doc)
+		addTime(&parseTime, parseStart)
+		if len(data.Artists) > 0 || attempt >= *retryEmpty {
+			break
+		}
+		if cache != nil {
+			cache.remove(url)
+		}
+		delay := retryDelay(attempt, 0)
+		log.Printf("No artists on %s, fetching it again in %v", url, delay)
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return Genre{}, fmt.Errorf("error fetching %s: %w: %w", genre, errNetwork, ctx.Err())
+		}
 	}
-	defer res.Body.Close()
 
-	doc, err := goquery.NewDocumentFromReader(res.Body)
-	if err != nil {
-		return Genre{}, fmt.Errorf("error parsing %s: %v", genre, err)
+	if data.Playlist == "" && len(data.Artists) == 0 && len(data.SimGenres) == 0 && len(data.OppGenres) == 0 {
+		return Genre{}, fmt.Errorf("error scraping %s: %w", genre, errPageMissing)
+	}
+	if data.ArtistCountHint > 0 && data.ArtistCountHint != len(data.Artists) {
+		log.Printf("WARNING: %s says it has %d artists but %d were parsed; the page may have content we didn't extract", genre, data.ArtistCountHint, len(data.Artists))
 	}
+	if artistsFilter != nil {
+		data = artistsFilter.apply(data)
+	}
+	if *numericWeights {
+		checkWeights(genre, data.ArtistWeights)
+		checkWeights(genre, data.SimWeights)
+		checkWeights(genre, data.OppWeights)
+	}
+	data = dropLightWeights(data)
+	data.ScrapedAt = time.Now().UTC().Format(time.RFC3339)
+	return data, nil
+}
 
-	playlist := ""
-	doc.Find("a").Each(func(i int, s *goquery.Selection) {
-		if s.Text() == "playlist" {
-			playlist, _ = s.Attr("href")
+// parseGenrePage extracts the playlist, artists and similar/opposite genres
+// from a genre page in a single pass over the matching nodes. The playlist is
+// the first "playlist" link on the page. It is safe to call from multiple
+// goroutines.
+func parseGenrePage(doc *goquery.Document) Genre {
+	data := Genre{CanonicalName: pageGenreName(doc), ArtistCountHint: pageArtistCount(doc)}
+
+	doc.Find("a, div.genre").Each(func(i int, s *goquery.Selection) {
+		if goquery.NodeName(s) == "a" {
+			// The first link labelled "playlist" (in any case, ignoring
+			// surrounding whitespace) with an href wins; later ones are skipped.
+			if data.Playlist == "" && strings.EqualFold(strings.TrimSpace(s.Text()), "playlist") {
+				data.Playlist, _ = s.Attr("href")
+				return
+			}
+			if *mapLinks {
+				if href, ok := mapLink(s); ok && indexOf(data.MapLinks, href) < 0 {
+					data.MapLinks = append(data.MapLinks, href)
+					data.MapLinkLabels = append(data.MapLinkLabels, normalizeGenreName(s.Text()))
+				}
+			}
+			return
 		}
-	})
-
-	var artistWeights, artists, simWeights, oppWeights, simGenres, oppGenres []string
 
-	doc.Find("div.genre.scanme").Each(func(i int, s *goquery.Selection) {
 		style, _ := s.Attr("style")
-		artist := strings.TrimSuffix(strings.TrimSpace(s.Text()), "»")
-		weight := extractWeight(style)
+		weight := styles.weight(style)
+		name := normalizeGenreName(s.Text())
 
-		artistWeightsMu.Lock()
-		if existingWeight, ok := artistsWeights[artist]; ok {
-			weight = existingWeight
-		} else {
-			artistsWeights[artist] = weight
-		}
-		artistWeightsMu.Unlock()
+		if s.HasClass("scanme") {
+			if !*noSharedWeights {
+				weight = sharedWeight(name, weight)
+			}
 
-		artistWeights = append(artistWeights, weight)
-		artists = append(artists, artist)
-	})
+			data.ArtistWeights = append(data.ArtistWeights, weight)
+			data.Artists = append(data.Artists, name)
+			if *artistPreviews {
+				data.ArtistPreviews = append(data.ArtistPreviews, artistPreview(s))
+			}
+			return
+		}
 
-	doc.Find("div.genre").Not(".scanme").Each(func(i int, s *goquery.Selection) {
 		id, _ := s.Attr("id")
-		style, _ := s.Attr("style")
-		weight := extractWeight(style)
-		genreName := strings.TrimSuffix(strings.TrimSpace(s.Text()), "»")
 		if strings.Contains(id, "nearby") {
-			simWeights = append(simWeights, weight)
-			simGenres = append(simGenres, genreName)
+			data.SimWeights = append(data.SimWeights, weight)
+			data.SimGenres = append(data.SimGenres, name)
 		} else if strings.Contains(id, "mirror") {
-			oppWeights = append(oppWeights, weight)
-			oppGenres = append(oppGenres, genreName)
+			data.OppWeights = append(data.OppWeights, weight)
+			data.OppGenres = append(data.OppGenres, name)
 		}
 	})
 
-	return Genre{
-		Playlist:      playlist,
-		ArtistWeights: artistWeights,
-		Artists:       artists,
-		SimWeights:    simWeights,
-		OppWeights:    oppWeights,
-		SimGenres:     simGenres,
-		OppGenres:     oppGenres,
-	}, nil
+	return data
+}
+
+// pageGenreName returns the genre name from a genre page's title, which reads
+// "Every Noise at Once - <genre>". It can differ from the name on the map in
+// capitalization or spelling.
+func pageGenreName(doc *goquery.Document) string {
+	title := strings.TrimSpace(doc.Find("title").First().Text())
+	if i := strings.LastIndex(title, " - "); i >= 0 {
+		title = title[i+len(" - "):]
+	}
+	return strings.TrimSpace(title)
+}
+
+// mapLink returns the href of a link to another everynoise page, relative or
+// on everynoise.com. Links inside genre elements are the similar and opposite
+// genres themselves and are skipped.
+func mapLink(s *goquery.Selection) (string, bool) {
+	href, _ := s.Attr("href")
+	if href == "" || s.Closest("div.genre").Length() > 0 {
+		return "", false
+	}
+	u, err := url.Parse(href)
+	if err != nil || !strings.HasSuffix(u.Path, ".html") {
+		return "", false
+	}
+	if u.Host != "" && u.Host != "everynoise.com" && u.Host != "www.everynoise.com" {
+		return "", false
+	}
+	return href, true
+}
+
+var (
+	spotifyURIPattern = regexp.MustCompile(`spotify:[a-z]+:[A-Za-z0-9]+`)
+	previewURLPattern = regexp.MustCompile(`https?://[^\s"']+`)
+)
+
+// artistPreview returns the Spotify URI or preview URL an artist element
+// plays when clicked, taken from its onclick handler, or its preview_url
+// attribute when the handler has neither.
+func artistPreview(s *goquery.Selection) string {
+	onclick, _ := s.Attr("onclick")
+	if uri := spotifyURIPattern.FindString(onclick); uri != "" {
+		return uri
+	}
+	if u := previewURLPattern.FindString(onclick); u != "" {
+		return u
+	}
+	preview, _ := s.Attr("preview_url")
+	return strings.TrimSpace(preview)
+}
+
+var artistCountPattern = regexp.MustCompile(`(?i)(\d[\d,]*)\s+artists`)
+
+// pageArtistCount returns the artist count stated in a genre page's header,
+// e.g. "1,234 artists", or 0 when the page doesn't state one.
+func pageArtistCount(doc *goquery.Document) int {
+	header := doc.Find("div.title, h1, h2").First().Text()
+	match := artistCountPattern.FindStringSubmatch(header)
+	if match == nil {
+		return 0
+	}
+	count, _ := strconv.Atoi(strings.ReplaceAll(match[1], ",", ""))
+	return count
+}
+
+// normalizeGenreName turns an element's text into a genre or artist name.
+// Text() joins nested elements, so the link marker can appear anywhere, not
+// just as a suffix, and surrounded by any whitespace; it is removed wherever
+// it is, leading and trailing whitespace is trimmed and internal runs of
+// whitespace collapse to a single space.
+func normalizeGenreName(raw string) string {
+	if *linkMarker != "" {
+		raw = strings.ReplaceAll(raw, *linkMarker, " ")
+	}
+	return strings.Join(strings.Fields(raw), " ")
 }
 
-func extractWeight(style string) string {
-	if match := fontSizeRe.FindStringSubmatch(style); len(match) > 1 {
-		return strings.TrimSuffix(strings.TrimSpace(match[1]), "%")
+// sharedWeight returns the weight first recorded for artist, recording weight
+// if the artist has not been seen yet.
+func sharedWeight(artist, weight string) string {
+	artistWeightsMu.Lock()
+	defer artistWeightsMu.Unlock()
+	if existingWeight, ok := artistsWeights[artist]; ok {
+		return existingWeight
 	}
-	return ""
+	artistsWeights[artist] = weight
+	return weight
 }
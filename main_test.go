@@ -0,0 +1,208 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+func loadFixture(t testing.TB, name string) *goquery.Document {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return parseFixture(t, string(data))
+}
+
+func parseFixture(t testing.TB, page string) *goquery.Document {
+	t.Helper()
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(page))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return doc
+}
+
+// withFlag sets a boolean flag for the rest of the test.
+func withFlag(t testing.TB, f *bool, value bool) {
+	saved := *f
+	*f = value
+	t.Cleanup(func() { *f = saved })
+}
+
+func TestParseGenrePageGolden(t *testing.T) {
+	withFlag(t, noSharedWeights, true)
+
+	tests := []struct {
+		golden string
+		extras bool
+	}{
+		{"genre.golden.json", false},
+		{"genre-extras.golden.json", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.golden, func(t *testing.T) {
+			withFlag(t, artistPreviews, tt.extras)
+			withFlag(t, mapLinks, tt.extras)
+
+			got, err := json.MarshalIndent(parseGenrePage(loadFixture(t, "genre.html")), "", "  ")
+			if err != nil {
+				t.Fatal(err)
+			}
+			got = append(got, '\n')
+
+			path := filepath.Join("testdata", tt.golden)
+			if *update {
+				if err := os.WriteFile(path, got, 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			want, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, want) {
+				t.Errorf("parseGenrePage output differs from %s:\n%s", path, got)
+			}
+		})
+	}
+}
+
+// BenchmarkParseGenrePage parses a page the size of a large genre, with a
+// thousand artists and a hundred similar and opposite genres.
+func BenchmarkParseGenrePage(b *testing.B) {
+	withFlag(b, noSharedWeights, true)
+
+	var page strings.Builder
+	page.WriteString(`<html><head><title>Every Noise at Once - pop</title></head><body>`)
+	page.WriteString(`<div class="title">pop <a href="https://open.spotify.com/playlist/x">playlist</a> 1,000 artists</div>`)
+	for i := 0; i < 1000; i++ {
+		fmt.Fprintf(&page, `<div id="item%d" class="genre scanme" style="color: #a0a0a0; top: %dpx; left: 10px; font-size: %d%%">Artist %d<a class="navlink" href="?root=Artist">»</a></div>`, i, i*20, 100+i%80, i)
+	}
+	for i := 0; i < 100; i++ {
+		fmt.Fprintf(&page, `<div id="nearby%d" class="genre" style="font-size: 120%%">near %d<a href="engenremap-near%d.html">»</a></div>`, i, i, i)
+		fmt.Fprintf(&page, `<div id="mirror%d" class="genre" style="font-size: 110%%">far %d<a href="engenremap-far%d.html">»</a></div>`, i, i, i)
+	}
+	page.WriteString(`</body></html>`)
+	doc := parseFixture(b, page.String())
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		parseGenrePage(doc)
+	}
+}
+
+func TestDedupGenres(t *testing.T) {
+	genres := parseGenreList(loadFixture(t, "genre-list.html"))
+	if len(genres) != 4 {
+		t.Fatalf("parseGenreList found %d genres, want 4", len(genres))
+	}
+
+	unique, dropped := dedupGenres(genres)
+	if dropped != 1 {
+		t.Errorf("dedupGenres dropped %d genres, want 1", dropped)
+	}
+	var names []string
+	for _, genre := range unique {
+		names = append(names, genre.Name)
+	}
+	if got, want := strings.Join(names, ","), "pop,shoegaze,r&b"; got != want {
+		t.Errorf("dedupGenres kept %s, want %s", got, want)
+	}
+	// The first entry for a name wins
+	if unique[0].Top != "3968px" {
+		t.Errorf("kept pop at top %s, want the first entry at 3968px", unique[0].Top)
+	}
+}
+
+// Text() joins nested elements, so markup can leave the marker in the middle
+// of a name rather than at its end.
+func TestNormalizeGenreNameNestedMarker(t *testing.T) {
+	withFlag(t, noSharedWeights, true)
+	doc := parseFixture(t, `<html><body>
+<div id="item1" class="genre scanme" style="font-size: 100%"><span>Sigur<a class="navlink" href="?root=Sigur">»</a></span><span>Rós</span></div>
+<div id="item2" class="genre scanme" style="font-size: 100%"><b>»</b>Múm <i>»</i> </div>
+<div id="nearby0" class="genre" style="font-size: 100%">post<span>»</span>rock<a href="engenremap-postrock.html">»</a></div>
+</body></html>`)
+
+	data := parseGenrePage(doc)
+	if got, want := strings.Join(data.Artists, ","), "Sigur Rós,Múm"; got != want {
+		t.Errorf("artists = %s, want %s", got, want)
+	}
+	if got, want := strings.Join(data.SimGenres, ","), "post rock"; got != want {
+		t.Errorf("similar genres = %s, want %s", got, want)
+	}
+
+	var names []string
+	for _, genre := range parseGenreList(doc) {
+		names = append(names, genre.Name)
+	}
+	if got, want := strings.Join(names, ","), "Sigur Rós,Múm"; got != want {
+		t.Errorf("genre list = %s, want %s", got, want)
+	}
+}
+
+func TestParseGenrePageFirstPlaylist(t *testing.T) {
+	doc := parseFixture(t, `<html><body>
+<a href="https://open.spotify.com/playlist/other">playlists</a>
+<a>playlist</a>
+<a href="https://open.spotify.com/playlist/first">
+  PlayList
+</a>
+<a href="https://open.spotify.com/playlist/second">playlist</a>
+<a href="https://open.spotify.com/playlist/third"> PLAYLIST </a>
+</body></html>`)
+
+	if got, want := parseGenrePage(doc).Playlist, "https://open.spotify.com/playlist/first"; got != want {
+		t.Errorf("playlist = %s, want %s", got, want)
+	}
+}
+
+func TestNormalizeGenreName(t *testing.T) {
+	tests := []struct {
+		raw, want string
+	}{
+		{"shoegaze", "shoegaze"},
+		{"shoegaze»", "shoegaze"},
+		{"shoegaze »", "shoegaze"},
+		{"shoegaze» ", "shoegaze"},
+		{"  shoegaze  »  ", "shoegaze"},
+		{"»shoegaze", "shoegaze"},
+		{"» » shoegaze»»", "shoegaze"},
+		{"dream\n\tpop", "dream pop"},
+		{"dream   »  pop", "dream pop"},
+		{"r&b»", "r&b"},
+		{"»", ""},
+		{"   ", ""},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := normalizeGenreName(tt.raw); got != tt.want {
+			t.Errorf("normalizeGenreName(%q) = %q, want %q", tt.raw, got, tt.want)
+		}
+	}
+}
+
+func TestNormalizeGenreNameMarker(t *testing.T) {
+	saved := *linkMarker
+	defer func() { *linkMarker = saved }()
+
+	*linkMarker = ">>"
+	if got := normalizeGenreName("pop >> » "); got != "pop »" {
+		t.Errorf("with --marker >>, got %q, want %q", got, "pop »")
+	}
+	*linkMarker = ""
+	if got := normalizeGenreName(" pop» "); got != "pop»" {
+		t.Errorf("with an empty --marker, got %q, want %q", got, "pop»")
+	}
+}
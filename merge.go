@@ -0,0 +1,282 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// isMergeKeep reports whether keep is a --merge-keep policy.
+func isMergeKeep(keep string) bool {
+	switch keep {
+	case "first", "last", "newest":
+		return true
+	}
+	return false
+}
+
+// mergeOutputs reads the genres in paths and writes them to --out, keeping one
+// genre per name. Genres are written in the order their name first appears;
+// --merge-keep picks which copy of a repeated name is kept: the first or last
+// seen in argument order, or the most recently scraped one. "newest" compares
+// ScrapedAt, falling back to the file's modification time for rows written
+// before it was recorded.
+func mergeOutputs(paths []string) error {
+	var (
+		order     []string
+		merged    = make(map[string]Genre)
+		scrapedAt = make(map[string]time.Time)
+	)
+	for _, path := range paths {
+		for _, target := range outputs {
+			if path == target.path {
+				return fmt.Errorf("%s is both an input and --out", path)
+			}
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			return err
+		}
+		genres, err := readOutputFile(path)
+		if err != nil {
+			return fmt.Errorf("error reading %s: %v", path, err)
+		}
+		log.Printf("Read %d genres from %s", len(genres), path)
+
+		for _, genre := range genres {
+			_, seen := merged[genre.Name]
+			if !seen {
+				order = append(order, genre.Name)
+			}
+			scraped := info.ModTime()
+			if t, err := time.Parse(time.RFC3339, genre.ScrapedAt); err == nil {
+				scraped = t
+			}
+			keep := !seen || *mergeKeep == "last" ||
+				(*mergeKeep == "newest" && !scraped.Before(scrapedAt[genre.Name]))
+			if keep {
+				merged[genre.Name] = genre
+				scrapedAt[genre.Name] = scraped
+			}
+		}
+	}
+
+	writer, err := newOutputWriter()
+	if err != nil {
+		return fmt.Errorf("cannot create output: %v", err)
+	}
+	for _, name := range order {
+		if err := writer.Write(merged[name]); err != nil {
+			writer.Close()
+			return fmt.Errorf("error writing %s: %v", name, err)
+		}
+	}
+	if err := writer.Close(); err != nil {
+		return fmt.Errorf("error closing output: %v", err)
+	}
+	log.Printf("Merged %d genres from %d files into %s", len(order), len(paths), outputNames())
+	return nil
+}
+
+// readOutputFile reads a csv, json or jsonl output file, gzip-compressed if
+// its name ends in .gz. The format is taken from the extension.
+func readOutputFile(path string) ([]Genre, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var r io.Reader = file
+	name := path
+	if strings.HasSuffix(name, ".gz") {
+		gz, err := gzip.NewReader(file)
+		if err != nil {
+			return nil, err
+		}
+		defer gz.Close()
+		r = gz
+		name = strings.TrimSuffix(name, ".gz")
+	}
+
+	switch ext := filepath.Ext(name); ext {
+	case ".csv":
+		return readCSVGenres(r)
+	case ".json", ".jsonl":
+		return readJSONGenres(r)
+	default:
+		return nil, fmt.Errorf("cannot merge %q files; use csv, json or jsonl", ext)
+	}
+}
+
+// readCSVGenres reads genres written by csvWriter, matching columns by their
+// header so files with and without --style-extras can be merged.
+func readCSVGenres(r io.Reader) ([]Genre, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	header, err := reader.Read()
+	if err == io.EOF {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if indexOf(header, "Genre") < 0 {
+		return nil, fmt.Errorf("no Genre column")
+	}
+
+	var genres []Genre
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			return genres, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		column := func(name string) string {
+			if i := indexOf(header, name); i >= 0 && i < len(record) {
+				return record[i]
+			}
+			return ""
+		}
+		list := func(name string) []string {
+			if value := column(name); value != "" {
+				return strings.Split(value, "|")
+			}
+			return nil
+		}
+		hint, err := artistCountColumn(column("ArtistCountHint"))
+		if err != nil {
+			line, _ := reader.FieldPos(0)
+			return nil, fmt.Errorf("line %d: %v", line, err)
+		}
+		genre, err := alignLists(Genre{
+			Name:            column("Genre"),
+			CanonicalName:   column("CanonicalName"),
+			Playlist:        column("Playlist"),
+			FontSize:        column("FontSize"),
+			ColorHex:        column("ColorHex"),
+			ColorRGB:        column("ColorRGB"),
+			Top:             column("Top"),
+			Left:            column("Left"),
+			ArtistWeights:   list("ArtistWeights"),
+			Artists:         list("Artists"),
+			SimWeights:      list("SimWeights"),
+			SimGenres:       list("SimGenres"),
+			OppWeights:      list("OppWeights"),
+			OppGenres:       list("OppGenres"),
+			ZIndex:          column("ZIndex"),
+			Opacity:         column("Opacity"),
+			ArtistPreviews:  list("ArtistPreviews"),
+			MapLinks:        list("MapLinks"),
+			MapLinkLabels:   list("MapLinkLabels"),
+			ArtistCountHint: hint,
+			ScrapedAt:       column("ScrapedAt"),
+		})
+		if err != nil {
+			line, _ := reader.FieldPos(0)
+			return nil, fmt.Errorf("line %d: %v", line, err)
+		}
+		genres = append(genres, genre)
+	}
+}
+
+// artistCountColumn parses an ArtistCountHint column, which is empty when the
+// page didn't state a count or the file predates the column.
+func artistCountColumn(value string) (int, error) {
+	if value == "" {
+		return 0, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid ArtistCountHint %q", value)
+	}
+	return n, nil
+}
+
+// readJSONGenres reads either a JSON array of genres or one genre per line.
+func readJSONGenres(r io.Reader) ([]Genre, error) {
+	buf := bufio.NewReader(r)
+	start, err := buf.Peek(1)
+	for err == nil && len(bytes.TrimSpace(start)) == 0 {
+		buf.ReadByte()
+		start, err = buf.Peek(1)
+	}
+	if err == io.EOF {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var docs []jsonGenre
+	decoder := json.NewDecoder(buf)
+	if start[0] == '[' {
+		if err := decoder.Decode(&docs); err != nil {
+			return nil, err
+		}
+	} else {
+		for {
+			var doc jsonGenre
+			err := decoder.Decode(&doc)
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return nil, err
+			}
+			docs = append(docs, doc)
+		}
+	}
+
+	genres := make([]Genre, len(docs))
+	for i, doc := range docs {
+		genre, err := alignLists(doc.genre())
+		if err != nil {
+			return nil, err
+		}
+		genres[i] = genre
+	}
+	return genres, nil
+}
+
+// alignLists checks that each list of names has one weight (or label) per
+// name, since the writers index them in parallel. A CSV can't tell a single
+// empty weight from no weights, so an empty list is padded to the length of
+// its names; any other mismatch, e.g. from a name containing "|", is an error.
+func alignLists(genre Genre) (Genre, error) {
+	pairs := []struct {
+		names, values *[]string
+		what          string
+	}{
+		{&genre.Artists, &genre.ArtistWeights, "artist weights"},
+		{&genre.SimGenres, &genre.SimWeights, "similar genre weights"},
+		{&genre.OppGenres, &genre.OppWeights, "opposite genre weights"},
+		{&genre.MapLinks, &genre.MapLinkLabels, "map link labels"},
+	}
+	for _, pair := range pairs {
+		names, values := *pair.names, *pair.values
+		switch {
+		case len(values) == len(names):
+		case len(values) == 0:
+			*pair.values = make([]string, len(names))
+		default:
+			return Genre{}, fmt.Errorf("%s: %d names but %d %s", genre.Name, len(names), len(values), pair.what)
+		}
+	}
+	if len(genre.ArtistPreviews) > 0 && len(genre.ArtistPreviews) != len(genre.Artists) {
+		return Genre{}, fmt.Errorf("%s: %d artists but %d previews", genre.Name, len(genre.Artists), len(genre.ArtistPreviews))
+	}
+	return genre, nil
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeGenres writes genres to path with the regular output writer.
+func writeGenres(t *testing.T, format, path string, genres []Genre) {
+	t.Helper()
+	w, err := newGenreWriter(format, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, genre := range genres {
+		if err := w.Write(genre); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// runMerge merges paths into out with --merge-keep keep and reads the result.
+func runMerge(t *testing.T, keep string, out outputTarget, paths ...string) ([]Genre, error) {
+	t.Helper()
+	savedKeep, savedOutputs := *mergeKeep, outputs
+	defer func() { *mergeKeep, outputs = savedKeep, savedOutputs }()
+	*mergeKeep, outputs = keep, []outputTarget{out}
+
+	if err := mergeOutputs(paths); err != nil {
+		return nil, err
+	}
+	genres, err := readOutputFile(out.path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return genres, nil
+}
+
+func mergeGenre(name, source, scrapedAt string) Genre {
+	return Genre{
+		Name:            name,
+		Playlist:        "https://open.spotify.com/playlist/" + source,
+		ColorHex:        "#aa0000",
+		Top:             "10px",
+		Left:            "20px",
+		ArtistWeights:   []string{"120", "100"},
+		Artists:         []string{name + " artist", source},
+		SimWeights:      []string{"90"},
+		SimGenres:       []string{"r&b"},
+		OppWeights:      []string{},
+		OppGenres:       []string{},
+		ArtistCountHint: 2,
+		ScrapedAt:       scrapedAt,
+	}
+}
+
+func TestMergeKeep(t *testing.T) {
+	dir := t.TempDir()
+	csvInput := filepath.Join(dir, "a.csv")
+	jsonlInput := filepath.Join(dir, "b.jsonl")
+	inputs := map[string]Genre{}
+	for _, genre := range []Genre{
+		mergeGenre("pop", "a", "2024-01-02T00:00:00Z"),
+		mergeGenre("rock", "a", "2024-01-01T00:00:00Z"),
+		mergeGenre("pop", "b", "2024-01-01T00:00:00Z"),
+		mergeGenre("jazz", "b", "2024-01-01T00:00:00Z"),
+		mergeGenre("rock", "b", "2024-03-01T00:00:00Z"),
+	} {
+		inputs[genre.Name+"/"+genre.Artists[1]] = genre
+	}
+	writeGenres(t, "csv", csvInput, []Genre{inputs["pop/a"], inputs["rock/a"]})
+	writeGenres(t, "jsonl", jsonlInput, []Genre{inputs["pop/b"], inputs["jazz/b"], inputs["rock/b"]})
+
+	// Which input each genre should come from under each policy
+	tests := []struct {
+		keep string
+		want map[string]string
+	}{
+		{"first", map[string]string{"pop": "a", "rock": "a", "jazz": "b"}},
+		{"last", map[string]string{"pop": "b", "rock": "b", "jazz": "b"}},
+		{"newest", map[string]string{"pop": "a", "rock": "b", "jazz": "b"}},
+	}
+	for _, format := range []string{"csv", "jsonl"} {
+		for _, tt := range tests {
+			t.Run(format+"/"+tt.keep, func(t *testing.T) {
+				out := outputTarget{format, filepath.Join(t.TempDir(), "merged."+format)}
+				genres, err := runMerge(t, tt.keep, out, csvInput, jsonlInput)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				var names []string
+				for _, genre := range genres {
+					names = append(names, genre.Name)
+				}
+				if got := strings.Join(names, ","); got != "pop,rock,jazz" {
+					t.Fatalf("merged genres = %s, want pop,rock,jazz", got)
+				}
+				for _, genre := range genres {
+					source := tt.want[genre.Name]
+					want := inputs[genre.Name+"/"+source]
+					if len(genre.OppGenres) != 0 || len(genre.OppWeights) != 0 {
+						t.Errorf("%s has opposite genres %q", genre.Name, genre.OppGenres)
+					}
+					// Empty lists may read back as nil
+					genre.OppWeights, genre.OppGenres = want.OppWeights, want.OppGenres
+					if !reflect.DeepEqual(genre, want) {
+						t.Errorf("%s = %+v, want the copy from %s: %+v", genre.Name, genre, source, want)
+					}
+				}
+			})
+		}
+	}
+}
+
+// Rows without ScrapedAt fall back to the file's modification time.
+func TestMergeKeepNewestModTime(t *testing.T) {
+	dir := t.TempDir()
+	newer := filepath.Join(dir, "newer.csv")
+	older := filepath.Join(dir, "older.jsonl")
+	writeGenres(t, "csv", newer, []Genre{mergeGenre("pop", "newer", "")})
+	writeGenres(t, "jsonl", older, []Genre{mergeGenre("pop", "older", "")})
+	now := time.Now()
+	if err := os.Chtimes(newer, now, now); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(older, now.Add(-time.Hour), now.Add(-time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+
+	out := outputTarget{"jsonl", filepath.Join(dir, "merged.jsonl")}
+	genres, err := runMerge(t, "newest", out, newer, older)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(genres) != 1 || !strings.HasSuffix(genres[0].Playlist, "/newer") {
+		t.Errorf("kept %+v, want the copy from the newer file", genres)
+	}
+}
+
+func TestMergeRejectsMisalignedRows(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "bad.csv")
+	data := "Genre,Playlist,ArtistWeights,Artists\n" +
+		"pop,p,1|2,a|b\n" +
+		"rock,p,1,a|b|c\n"
+	if err := os.WriteFile(input, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	out := outputTarget{"dot", filepath.Join(dir, "merged.dot")}
+	_, err := runMerge(t, "first", out, input)
+	if err == nil {
+		t.Fatal("merge accepted a row with 3 artists and 1 weight")
+	}
+	if !strings.Contains(err.Error(), "line 3") {
+		t.Errorf("error %q doesn't name line 3", err)
+	}
+}
+
+func TestAlignLists(t *testing.T) {
+	// A CSV reads one artist with an empty weight back as no weights at all
+	genre, err := alignLists(Genre{Name: "pop", Artists: []string{"solo"}, SimGenres: []string{"a", "b"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(genre.ArtistWeights) != 1 || len(genre.SimWeights) != 2 {
+		t.Errorf("weights weren't padded: %q, %q", genre.ArtistWeights, genre.SimWeights)
+	}
+
+	bad := []Genre{
+		{Name: "pop", Artists: []string{"a|b"}, ArtistWeights: []string{"1", "2"}},
+		{Name: "pop", OppGenres: []string{"a"}, OppWeights: []string{"1", "2"}},
+		{Name: "pop", MapLinks: []string{"a.html"}, MapLinkLabels: []string{"a", "b"}},
+		{Name: "pop", Artists: []string{"a", "b"}, ArtistWeights: []string{"1", "2"}, ArtistPreviews: []string{"x"}},
+	}
+	for _, genre := range bad {
+		if _, err := alignLists(genre); err == nil {
+			t.Errorf("alignLists accepted %+v", genre)
+		}
+	}
+}
+
+func TestReadJSONGenres(t *testing.T) {
+	tests := []struct {
+		name, input string
+		want        []string
+	}{
+		{"array", `[{"name":"pop"},{"name":"rock"}]`, []string{"pop", "rock"}},
+		{"indented array", "\n\t  [\n {\"name\":\"pop\"}\n]\n", []string{"pop"}},
+		{"lines", "{\"name\":\"pop\"}\n{\"name\":\"rock\"}\n", []string{"pop", "rock"}},
+		{"lines after blank lines", "\n\n{\"name\":\"pop\"}\n", []string{"pop"}},
+		{"empty", "", nil},
+		{"whitespace only", " \n\t", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			genres, err := readJSONGenres(strings.NewReader(tt.input))
+			if err != nil {
+				t.Fatal(err)
+			}
+			var names []string
+			for _, genre := range genres {
+				names = append(names, genre.Name)
+			}
+			if !reflect.DeepEqual(names, tt.want) {
+				t.Errorf("names = %q, want %q", names, tt.want)
+			}
+		})
+	}
+
+	if _, err := readJSONGenres(strings.NewReader(`[{"name":`)); err == nil {
+		t.Error("readJSONGenres accepted truncated JSON")
+	}
+}
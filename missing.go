@@ -0,0 +1,71 @@
+package main
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// readScrapedNames returns the genre names already present in a CSV output
+// file. A missing file has no genres yet.
+func readScrapedNames(path string) (map[string]bool, error) {
+	names := make(map[string]bool)
+
+	file, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return names, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	reader := csv.NewReader(file)
+	reader.FieldsPerRecord = -1
+	header, err := reader.Read()
+	if err == io.EOF {
+		return names, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	column := indexOf(header, "Genre")
+	if column < 0 {
+		return nil, fmt.Errorf("%s has no Genre column", path)
+	}
+
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			return names, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if column < len(record) {
+			names[record[column]] = true
+		}
+	}
+}
+
+// missingGenres returns the genres whose names are not in scraped.
+func missingGenres(genres []Genre, scraped map[string]bool) []Genre {
+	var missing []Genre
+	for _, genre := range genres {
+		if !scraped[genre.Name] {
+			missing = append(missing, genre)
+		}
+	}
+	return missing
+}
+
+func indexOf(values []string, value string) int {
+	for i, v := range values {
+		if v == value {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+type ndjsonGenre struct {
+	Type            string   `json:"_type"`
+	Name            string   `json:"name"`
+	CanonicalName   string   `json:"canonical_name"`
+	Playlist        string   `json:"playlist"`
+	FontSize        string   `json:"font_size"`
+	ColorHex        string   `json:"color_hex"`
+	ColorRGB        string   `json:"color_rgb"`
+	Top             string   `json:"top"`
+	Left            string   `json:"left"`
+	ArtistCount     int      `json:"artist_count"`
+	ArtistCountHint int      `json:"artist_count_hint,omitempty"`
+	ZIndex          *string  `json:"z_index,omitempty"`
+	Opacity         *string  `json:"opacity,omitempty"`
+	MapLinks        []string `json:"map_links,omitempty"`
+	MapLinkLabels   []string `json:"map_link_labels,omitempty"`
+}
+
+type ndjsonArtist struct {
+	Type    string      `json:"_type"`
+	Genre   string      `json:"genre"`
+	Artist  string      `json:"artist"`
+	Weight  weightValue `json:"weight"`
+	Rank    int         `json:"rank"`
+	Preview string      `json:"preview,omitempty"`
+}
+
+type ndjsonEdge struct {
+	Type     string      `json:"_type"`
+	Source   string      `json:"source"`
+	Target   string      `json:"target"`
+	Relation string      `json:"relation"`
+	Weight   weightValue `json:"weight"`
+}
+
+// ndjsonStream is one buffered newline-delimited JSON destination.
+type ndjsonStream struct {
+	out io.Writer
+	buf *bufio.Writer
+	enc *json.Encoder
+}
+
+func newNDJSONStream(out io.Writer) *ndjsonStream {
+	buf := bufio.NewWriter(out)
+	return &ndjsonStream{out: out, buf: buf, enc: json.NewEncoder(buf)}
+}
+
+func (s *ndjsonStream) flush() error {
+	if err := s.buf.Flush(); err != nil {
+		return err
+	}
+	return flushOutput(s.out)
+}
+
+func (s *ndjsonStream) close() error {
+	if err := s.buf.Flush(); err != nil {
+		closeOutput(s.out)
+		return err
+	}
+	return closeOutput(s.out)
+}
+
+// ndjsonWriter writes one document per line tagged with _type: genres,
+// artist occurrences and similar/opposite edges, each to its own stream.
+type ndjsonWriter struct {
+	genres, artists, edges *ndjsonStream
+	streams                []*ndjsonStream
+}
+
+// newNDJSONWriter writes each document type to its own writer. Passing the
+// same writer more than once interleaves those types through one buffer, so
+// lines are never split.
+func newNDJSONWriter(genres, artists, edges io.Writer) *ndjsonWriter {
+	w := &ndjsonWriter{}
+	byWriter := make(map[io.Writer]*ndjsonStream)
+	stream := func(out io.Writer) *ndjsonStream {
+		if s, ok := byWriter[out]; ok {
+			return s
+		}
+		s := newNDJSONStream(out)
+		byWriter[out] = s
+		w.streams = append(w.streams, s)
+		return s
+	}
+	w.genres = stream(genres)
+	w.artists = stream(artists)
+	w.edges = stream(edges)
+	return w
+}
+
+// createNDJSONDir writes genres.ndjson, artists.ndjson and edges.ndjson
+// inside dir.
+func createNDJSONDir(dir string) (*ndjsonWriter, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	var files []io.Writer
+	for _, name := range []string{"genres.ndjson", "artists.ndjson", "edges.ndjson"} {
+		file, err := createOutput(filepath.Join(dir, name))
+		if err != nil {
+			for _, f := range files {
+				closeOutput(f)
+			}
+			return nil, err
+		}
+		files = append(files, file)
+	}
+	return newNDJSONWriter(files[0], files[1], files[2]), nil
+}
+
+func (w *ndjsonWriter) Write(genre Genre) error {
+	doc := ndjsonGenre{
+		Type:            "genre",
+		Name:            genre.Name,
+		CanonicalName:   genre.CanonicalName,
+		Playlist:        genre.Playlist,
+		FontSize:        genre.FontSize,
+		ColorHex:        genre.ColorHex,
+		ColorRGB:        genre.ColorRGB,
+		Top:             genre.Top,
+		Left:            genre.Left,
+		ArtistCount:     len(genre.Artists),
+		ArtistCountHint: genre.ArtistCountHint,
+		MapLinks:        genre.MapLinks,
+		MapLinkLabels:   genre.MapLinkLabels,
+	}
+	if *styleExtras {
+		doc.ZIndex = &genre.ZIndex
+		doc.Opacity = &genre.Opacity
+	}
+	if err := w.genres.enc.Encode(doc); err != nil {
+		return err
+	}
+
+	for i, artist := range genre.Artists {
+		entry := ndjsonArtist{
+			Type:   "artist",
+			Genre:  genre.Name,
+			Artist: artist,
+			Rank:   i + 1,
+		}
+		if i < len(genre.ArtistWeights) {
+			entry.Weight = weightValue(genre.ArtistWeights[i])
+		}
+		if i < len(genre.ArtistPreviews) {
+			entry.Preview = genre.ArtistPreviews[i]
+		}
+		if err := w.artists.enc.Encode(entry); err != nil {
+			return err
+		}
+	}
+
+	if err := w.writeEdges(genre.Name, "similar", genre.SimGenres, genre.SimWeights); err != nil {
+		return err
+	}
+	return w.writeEdges(genre.Name, "opposite", genre.OppGenres, genre.OppWeights)
+}
+
+func (w *ndjsonWriter) writeEdges(source, relation string, targets, weights []string) error {
+	for i, target := range targets {
+		edge := ndjsonEdge{
+			Type:     "edge",
+			Source:   source,
+			Target:   target,
+			Relation: relation,
+		}
+		if i < len(weights) {
+			edge.Weight = weightValue(weights[i])
+		}
+		if err := w.edges.enc.Encode(edge); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (w *ndjsonWriter) Flush() error {
+	for _, s := range w.streams {
+		if err := s.flush(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (w *ndjsonWriter) Close() error {
+	var firstErr error
+	for _, s := range w.streams {
+		if err := s.close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
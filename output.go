@@ -0,0 +1,550 @@
+package main
+
+import (
+	"compress/gzip"
+	"encoding/csv"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// genreWriter is an output format that scraped genres are streamed into.
+type genreWriter interface {
+	// Write buffers a single genre.
+	Write(genre Genre) error
+	// Flush persists every genre written so far.
+	Flush() error
+	// Close flushes any remaining genres and releases the output.
+	Close() error
+}
+
+func isOutputFormat(format string) bool {
+	switch format {
+	case "csv", "json", "jsonl", "parquet", "ndjson", "dot", "graphml":
+		return true
+	}
+	return false
+}
+
+// checkAppendable reports whether --append works with format. Line-based
+// formats can simply be appended to; a JSON array or Parquet file ends with a
+// closing bracket or footer, so it has to be rewritten.
+func checkAppendable(format string) error {
+	switch format {
+	case "csv", "jsonl", "ndjson":
+		return nil
+	case "json":
+		return fmt.Errorf("--append is not supported for json arrays; use --format jsonl")
+	}
+	return fmt.Errorf("--append is not supported for %s output", format)
+}
+
+// defaultOutputPath is used when --out is not given. The ndjson format writes
+// a directory of files rather than a single file.
+func defaultOutputPath(format string) string {
+	if format == "ndjson" {
+		return "genres-ndjson"
+	}
+	return "genres." + format
+}
+
+// stdoutPath as --out writes a single stream to standard output.
+const stdoutPath = "-"
+
+// stringList is a flag that can be given more than once.
+type stringList []string
+
+func (l *stringList) String() string { return strings.Join(*l, ",") }
+
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+func outputFlag(name, usage string) *stringList {
+	var l stringList
+	flag.Var(&l, name, usage)
+	return &l
+}
+
+// outputTarget is one --out destination and the format written to it.
+type outputTarget struct {
+	format string
+	path   string
+}
+
+// outputs are the destinations every genre is written to, set by
+// resolveOutputs.
+var outputs []outputTarget
+
+// resolveOutputs turns the --out flags into outputs. A single --out, or
+// none, is written in --format as before. With several, each is written in
+// the format its extension names, so one run can produce e.g. a CSV and a
+// GraphML file.
+func resolveOutputs() error {
+	paths := *outputPaths
+	switch len(paths) {
+	case 0:
+		outputs = []outputTarget{{*outputFormat, defaultOutputPath(*outputFormat)}}
+		return nil
+	case 1:
+		outputs = []outputTarget{{*outputFormat, paths[0]}}
+		return nil
+	}
+
+	outputs = nil
+	seen := make(map[string]bool)
+	for _, path := range paths {
+		if seen[path] {
+			return fmt.Errorf("--out %s is given twice", path)
+		}
+		seen[path] = true
+		format := formatFromPath(path)
+		if format == "" {
+			return fmt.Errorf("cannot tell the output format of %s from its extension; use .csv, .json, .jsonl, .parquet, .dot or .graphml", path)
+		}
+		outputs = append(outputs, outputTarget{format, path})
+	}
+	return nil
+}
+
+// formatFromPath returns the output format named by path's extension,
+// ignoring a trailing .gz, or "".
+func formatFromPath(path string) string {
+	switch filepath.Ext(strings.TrimSuffix(path, ".gz")) {
+	case ".csv":
+		return "csv"
+	case ".json":
+		return "json"
+	case ".jsonl":
+		return "jsonl"
+	case ".parquet":
+		return "parquet"
+	case ".dot", ".gv":
+		return "dot"
+	case ".graphml":
+		return "graphml"
+	}
+	return ""
+}
+
+// outputNames lists the output paths for log messages.
+func outputNames() string {
+	names := make([]string, len(outputs))
+	for i, target := range outputs {
+		names[i] = target.path
+	}
+	return strings.Join(names, ", ")
+}
+
+// newOutputWriter opens every output, fanning genres out to all of them when
+// there is more than one.
+func newOutputWriter() (genreWriter, error) {
+	var writers multiWriter
+	for _, target := range outputs {
+		writer, err := newGenreWriter(target.format, target.path)
+		if err != nil {
+			writers.Close()
+			return nil, fmt.Errorf("%s: %v", target.path, err)
+		}
+		writers = append(writers, writer)
+	}
+	if len(writers) == 1 {
+		return writers[0], nil
+	}
+	return writers, nil
+}
+
+// multiWriter writes every genre to each of its writers. A failure in one
+// doesn't stop the others; the errors are joined.
+type multiWriter []genreWriter
+
+func (m multiWriter) Write(genre Genre) error {
+	var errs []error
+	for _, w := range m {
+		errs = append(errs, w.Write(genre))
+	}
+	return errors.Join(errs...)
+}
+
+func (m multiWriter) Flush() error {
+	var errs []error
+	for _, w := range m {
+		errs = append(errs, w.Flush())
+	}
+	return errors.Join(errs...)
+}
+
+func (m multiWriter) Close() error {
+	var errs []error
+	for _, w := range m {
+		errs = append(errs, w.Close())
+	}
+	return errors.Join(errs...)
+}
+
+// outputFile is an opened output destination, gzip-compressed when --gzip is
+// set. Standard output is flushed but never closed.
+type outputFile struct {
+	file   *os.File
+	remote io.WriteCloser // an s3:// or gs:// upload, sent on Close
+	gz     *gzip.Writer
+	w      io.Writer
+
+	// existing is set when appending to a file that already had content, so
+	// formats with a header know not to repeat it.
+	existing bool
+}
+
+// createOutput creates path, or opens it for appending with --append. The
+// path "-" is standard output, and s3:// and gs:// URLs are uploaded once
+// the output is closed.
+func createOutput(path string) (*outputFile, error) {
+	if *gzipOutput && path != stdoutPath && !strings.HasSuffix(path, ".gz") {
+		path += ".gz"
+	}
+
+	var out *outputFile
+	if path == stdoutPath {
+		out = &outputFile{w: os.Stdout}
+	} else if remoteScheme(path) != "" {
+		remote, err := createRemote(path)
+		if err != nil {
+			return nil, err
+		}
+		out = &outputFile{remote: remote, w: remote}
+	} else {
+		flags := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+		if *appendOutput {
+			flags = os.O_WRONLY | os.O_CREATE | os.O_APPEND
+		}
+		file, err := os.OpenFile(path, flags, 0o644)
+		if err != nil {
+			return nil, err
+		}
+		out = &outputFile{file: file, w: file}
+		if *appendOutput {
+			info, err := file.Stat()
+			if err != nil {
+				file.Close()
+				return nil, err
+			}
+			out.existing = info.Size() > 0
+		}
+	}
+
+	if *gzipOutput {
+		gz, err := gzip.NewWriterLevel(out.w, *gzipLevel)
+		if err != nil {
+			out.Close()
+			return nil, err
+		}
+		out.gz = gz
+		out.w = gz
+	}
+	return out, nil
+}
+
+func (o *outputFile) Write(p []byte) (int, error) {
+	return o.w.Write(p)
+}
+
+// Flush pushes any data buffered by the compressor to the file and, with
+// --fsync, syncs the file to disk.
+func (o *outputFile) Flush() error {
+	if o.gz != nil {
+		if err := o.gz.Flush(); err != nil {
+			return err
+		}
+	}
+	if *fsyncOutput && o.file != nil {
+		return o.file.Sync()
+	}
+	return nil
+}
+
+func (o *outputFile) Close() error {
+	if o.gz != nil {
+		if err := o.gz.Close(); err != nil {
+			if o.file != nil {
+				o.file.Close()
+			}
+			return err
+		}
+	}
+	if o.remote != nil {
+		return o.remote.Close()
+	}
+	if o.file == nil {
+		return nil
+	}
+	return o.file.Close()
+}
+
+// flushOutput flushes w if it buffers, e.g. an outputFile compressing with
+// gzip. The writers below accept any io.Writer and use this and closeOutput
+// to pass Flush and Close through to it.
+func flushOutput(w io.Writer) error {
+	if f, ok := w.(interface{ Flush() error }); ok {
+		return f.Flush()
+	}
+	return nil
+}
+
+func closeOutput(w io.Writer) error {
+	if c, ok := w.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+// checkWritable fails if the output target can't be created, so that an
+// unwritable --out is reported before the genre list is fetched rather than
+// once scraping starts. It creates and removes a temporary file where the
+// output will go; the ndjson directory may not exist yet, so its nearest
+// existing parent is checked instead.
+func checkWritable(target outputTarget) error {
+	path := target.path
+	if path == stdoutPath || remoteScheme(path) != "" {
+		return nil
+	}
+	if *gzipOutput && !strings.HasSuffix(path, ".gz") {
+		path += ".gz"
+	}
+
+	dir := filepath.Dir(path)
+	if target.format == "ndjson" {
+		dir = path
+		for {
+			if _, err := os.Stat(dir); !errors.Is(err, fs.ErrNotExist) {
+				break
+			}
+			parent := filepath.Dir(dir)
+			if parent == dir {
+				break
+			}
+			dir = parent
+		}
+	} else if file, err := os.OpenFile(path, os.O_WRONLY, 0); err == nil {
+		file.Close()
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+
+	probe, err := os.CreateTemp(dir, ".write-check-*")
+	if err != nil {
+		return err
+	}
+	probe.Close()
+	return os.Remove(probe.Name())
+}
+
+// newGenreWriter opens the output at path and returns a writer for format.
+// The ndjson format writes a directory of files, or with "-" interleaves all
+// documents on standard output.
+func newGenreWriter(format, path string) (genreWriter, error) {
+	if format == "ndjson" {
+		if path == stdoutPath {
+			out, err := createOutput(path)
+			if err != nil {
+				return nil, err
+			}
+			return newNDJSONWriter(out, out, out), nil
+		}
+		return createNDJSONDir(path)
+	}
+
+	out, err := createOutput(path)
+	if err != nil {
+		return nil, err
+	}
+	switch format {
+	case "csv":
+		return newCSVWriter(out, !out.existing)
+	case "json":
+		return newJSONWriter(out, false), nil
+	case "jsonl":
+		return newJSONWriter(out, true), nil
+	case "parquet":
+		return newParquetWriter(out), nil
+	case "dot":
+		return newDOTWriter(out), nil
+	case "graphml":
+		return newGraphMLWriter(out), nil
+	}
+	out.Close()
+	return nil, fmt.Errorf("unknown output format %q", format)
+}
+
+// writeResults streams genres from results into writer, flushing every
+// batchSize genres, at least every --flush-interval while genres are pending,
+// and whenever flush is signalled. The writer is closed when results is.
+func writeResults(writer genreWriter, results <-chan Genre, flush <-chan struct{}, done chan<- struct{}, totalGenres int) {
+	defer close(done)
+	writer = timedWriter{writer}
+	defer func() {
+		if err := writer.Close(); err != nil {
+			log.Printf("Error closing output: %v", err)
+		}
+	}()
+
+	if *sortKey != "" {
+		results = sortResults(results, *sortKey)
+	}
+
+	var tick <-chan time.Time
+	if *flushInterval > 0 {
+		ticker := time.NewTicker(*flushInterval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	batch := 0
+	genreCount := 0
+
+loop:
+	for {
+		select {
+		case genre, ok := <-results:
+			if !ok {
+				break loop
+			}
+
+			if err := writer.Write(genre); err != nil {
+				log.Printf("Error writing %s: %v", genre.Name, err)
+				continue
+			}
+			for _, hook := range onGenre {
+				hook(genre)
+			}
+			batch++
+			genreCount++
+
+			if batch >= batchSize {
+				if err := writer.Flush(); err != nil {
+					log.Printf("Error writing batch: %v", err)
+				}
+				log.Printf("Wrote batch of %d genres. Total written: %d/%d", batch, genreCount, totalGenres)
+				batch = 0
+			}
+
+		case <-tick:
+			if batch == 0 {
+				continue
+			}
+			if err := writer.Flush(); err != nil {
+				log.Printf("Error writing batch: %v", err)
+			}
+			log.Printf("Wrote %d genres after %v. Total written: %d/%d", batch, *flushInterval, genreCount, totalGenres)
+			batch = 0
+
+		case <-flush:
+			if err := writer.Flush(); err != nil {
+				log.Printf("Error flushing output: %v", err)
+			}
+			log.Printf("Flushed output on request. Total written: %d/%d", genreCount, totalGenres)
+		}
+	}
+
+	// Write any remaining genres
+	if batch > 0 {
+		if err := writer.Flush(); err != nil {
+			log.Printf("Error writing final batch: %v", err)
+		}
+		log.Printf("Wrote final batch of %d genres. Total written: %d/%d", batch, genreCount, totalGenres)
+	}
+
+	log.Printf("Successfully wrote %d/%d genres to %s", genreCount, totalGenres, outputNames())
+}
+
+var csvHeaders = []string{"Genre", "Playlist", "FontSize", "ColorHex", "ColorRGB", "Top", "Left", "ArtistWeights", "Artists", "SimWeights", "SimGenres", "OppWeights", "OppGenres", "CanonicalName", "ArtistCountHint", "ScrapedAt"}
+
+type csvWriter struct {
+	out    io.Writer
+	writer *csv.Writer
+}
+
+func newCSVWriter(out io.Writer, header bool) (*csvWriter, error) {
+	writer := csv.NewWriter(out)
+	if header {
+		headers := csvHeaders
+		if *styleExtras {
+			headers = append(headers[:len(headers):len(headers)], "ZIndex", "Opacity")
+		}
+		if *artistPreviews {
+			headers = append(headers[:len(headers):len(headers)], "ArtistPreviews")
+		}
+		if *mapLinks {
+			headers = append(headers[:len(headers):len(headers)], "MapLinks", "MapLinkLabels")
+		}
+		if err := writer.Write(headers); err != nil {
+			closeOutput(out)
+			return nil, fmt.Errorf("error writing headers: %v", err)
+		}
+	}
+	return &csvWriter{out: out, writer: writer}, nil
+}
+
+func (w *csvWriter) Write(genre Genre) error {
+	row := []string{
+		genre.Name,
+		genre.Playlist,
+		genre.FontSize,
+		genre.ColorHex,
+		genre.ColorRGB,
+		genre.Top,
+		genre.Left,
+		strings.Join(genre.ArtistWeights, "|"),
+		strings.Join(genre.Artists, "|"),
+		strings.Join(genre.SimWeights, "|"),
+		strings.Join(genre.SimGenres, "|"),
+		strings.Join(genre.OppWeights, "|"),
+		strings.Join(genre.OppGenres, "|"),
+		genre.CanonicalName,
+		artistCountHint(genre),
+		genre.ScrapedAt,
+	}
+	if *styleExtras {
+		row = append(row, genre.ZIndex, genre.Opacity)
+	}
+	if *artistPreviews {
+		row = append(row, strings.Join(genre.ArtistPreviews, "|"))
+	}
+	if *mapLinks {
+		row = append(row, strings.Join(genre.MapLinks, "|"), strings.Join(genre.MapLinkLabels, "|"))
+	}
+	return w.writer.Write(row)
+}
+
+// artistCountHint formats a genre's ArtistCountHint, leaving it empty when
+// the page doesn't state a count.
+func artistCountHint(genre Genre) string {
+	if genre.ArtistCountHint == 0 {
+		return ""
+	}
+	return strconv.Itoa(genre.ArtistCountHint)
+}
+
+func (w *csvWriter) Flush() error {
+	w.writer.Flush()
+	if err := w.writer.Error(); err != nil {
+		return err
+	}
+	return flushOutput(w.out)
+}
+
+func (w *csvWriter) Close() error {
+	if err := w.Flush(); err != nil {
+		closeOutput(w.out)
+		return err
+	}
+	return closeOutput(w.out)
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestUnwritableOutput(t *testing.T) {
+	dir := t.TempDir()
+	notDir := filepath.Join(dir, "file")
+	if err := os.WriteFile(notDir, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	paths := map[string]string{
+		"missing directory": filepath.Join(dir, "missing", "genres.csv"),
+		"parent is a file":  filepath.Join(notDir, "genres.csv"),
+	}
+	for name, path := range paths {
+		t.Run(name, func(t *testing.T) {
+			savedPaths, savedOutputs := *outputPaths, outputs
+			defer func() { *outputPaths, outputs = savedPaths, savedOutputs }()
+			*outputPaths = stringList{path}
+
+			// validateFlags runs before anything is fetched
+			if err := validateFlags(); err == nil {
+				t.Errorf("validateFlags accepted --out %s", path)
+			}
+			if writer, err := newOutputWriter(); err == nil {
+				writer.Close()
+				t.Errorf("newOutputWriter opened %s", path)
+			}
+			if _, err := os.Stat(path); err == nil {
+				t.Errorf("%s was created", path)
+			}
+		})
+	}
+}
+
+func TestWritableOutput(t *testing.T) {
+	savedPaths, savedOutputs := *outputPaths, outputs
+	defer func() { *outputPaths, outputs = savedPaths, savedOutputs }()
+	dir := t.TempDir()
+	*outputPaths = stringList{filepath.Join(dir, "genres.csv")}
+
+	if err := validateFlags(); err != nil {
+		t.Fatalf("validateFlags: %v", err)
+	}
+	// The write check must not leave files behind
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 0 {
+		t.Errorf("validateFlags left %d files in the output directory", len(entries))
+	}
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"strings"
+)
+
+// paletteCollector tallies the map colors of every written genre. Its add
+// method is registered as an onGenre hook, so it sees genres serially.
+type paletteCollector struct {
+	colors map[string]*paletteColor
+}
+
+type paletteColor struct {
+	Hex    string   `json:"hex"`
+	RGB    string   `json:"rgb"`
+	HSL    string   `json:"hsl"`
+	Count  int      `json:"count"`
+	Genres []string `json:"genres"`
+}
+
+func newPaletteCollector() *paletteCollector {
+	return &paletteCollector{colors: make(map[string]*paletteColor)}
+}
+
+func (p *paletteCollector) add(genre Genre) {
+	if genre.Error != "" || genre.ColorHex == "" {
+		return
+	}
+	hex := strings.ToLower(genre.ColorHex)
+	color, ok := p.colors[hex]
+	if !ok {
+		r, g, b := hexToRGB(hex)
+		color = &paletteColor{
+			Hex: hex,
+			RGB: fmt.Sprintf("rgb(%d, %d, %d)", r, g, b),
+			HSL: rgbToHSL(r, g, b),
+		}
+		p.colors[hex] = color
+	}
+	color.Count++
+	color.Genres = append(color.Genres, genre.Name)
+}
+
+// write saves the palette as a JSON array, most-used colors first.
+func (p *paletteCollector) write(path string) error {
+	colors := make([]*paletteColor, 0, len(p.colors))
+	for _, color := range p.colors {
+		sort.Strings(color.Genres)
+		colors = append(colors, color)
+	}
+	sort.Slice(colors, func(i, j int) bool {
+		if colors[i].Count != colors[j].Count {
+			return colors[i].Count > colors[j].Count
+		}
+		return colors[i].Hex < colors[j].Hex
+	})
+
+	data, err := json.MarshalIndent(colors, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0o644)
+}
+
+func rgbToHSL(r, g, b int) string {
+	rf, gf, bf := float64(r)/255, float64(g)/255, float64(b)/255
+	hi := math.Max(rf, math.Max(gf, bf))
+	lo := math.Min(rf, math.Min(gf, bf))
+	l := (hi + lo) / 2
+
+	var h, s float64
+	if d := hi - lo; d > 0 {
+		if l > 0.5 {
+			s = d / (2 - hi - lo)
+		} else {
+			s = d / (hi + lo)
+		}
+		switch hi {
+		case rf:
+			h = math.Mod((gf-bf)/d, 6)
+		case gf:
+			h = (bf-rf)/d + 2
+		default:
+			h = (rf-gf)/d + 4
+		}
+		h *= 60
+		if h < 0 {
+			h += 360
+		}
+	}
+	return fmt.Sprintf("hsl(%.0f, %.0f%%, %.0f%%)", h, s*100, l*100)
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"io"
+	"strconv"
+
+	"github.com/parquet-go/parquet-go"
+)
+
+// parquetGenre is the Parquet row layout of a Genre. The parallel slices are
+// written as list columns so they load as arrays in pandas or Spark.
+// ArtistCountHint is 0 when the page doesn't state a count, and the map link
+// lists are empty without --map-links.
+type parquetGenre struct {
+	Name            string   `parquet:"name"`
+	CanonicalName   string   `parquet:"canonical_name"`
+	Playlist        string   `parquet:"playlist"`
+	FontSize        string   `parquet:"font_size"`
+	ColorHex        string   `parquet:"color_hex"`
+	ColorRGB        string   `parquet:"color_rgb"`
+	Top             string   `parquet:"top"`
+	Left            string   `parquet:"left"`
+	ArtistWeights   []string `parquet:"artist_weights,list"`
+	Artists         []string `parquet:"artists,list"`
+	SimWeights      []string `parquet:"sim_weights,list"`
+	SimGenres       []string `parquet:"sim_genres,list"`
+	OppWeights      []string `parquet:"opp_weights,list"`
+	OppGenres       []string `parquet:"opp_genres,list"`
+	ArtistCountHint int64    `parquet:"artist_count_hint"`
+	ZIndex          *string  `parquet:"z_index,optional"`
+	Opacity         *string  `parquet:"opacity,optional"`
+	MapLinks        []string `parquet:"map_links,list"`
+	MapLinkLabels   []string `parquet:"map_link_labels,list"`
+}
+
+// parquetNumericGenre is the row layout with --numeric-weights, which writes
+// the weights as list<double> columns. The other columns match parquetGenre.
+type parquetNumericGenre struct {
+	Name            string    `parquet:"name"`
+	CanonicalName   string    `parquet:"canonical_name"`
+	Playlist        string    `parquet:"playlist"`
+	FontSize        string    `parquet:"font_size"`
+	ColorHex        string    `parquet:"color_hex"`
+	ColorRGB        string    `parquet:"color_rgb"`
+	Top             string    `parquet:"top"`
+	Left            string    `parquet:"left"`
+	ArtistWeights   []float64 `parquet:"artist_weights,list"`
+	Artists         []string  `parquet:"artists,list"`
+	SimWeights      []float64 `parquet:"sim_weights,list"`
+	SimGenres       []string  `parquet:"sim_genres,list"`
+	OppWeights      []float64 `parquet:"opp_weights,list"`
+	OppGenres       []string  `parquet:"opp_genres,list"`
+	ArtistCountHint int64     `parquet:"artist_count_hint"`
+	ZIndex          *string   `parquet:"z_index,optional"`
+	Opacity         *string   `parquet:"opacity,optional"`
+	MapLinks        []string  `parquet:"map_links,list"`
+	MapLinkLabels   []string  `parquet:"map_link_labels,list"`
+}
+
+// parquetWriter writes one row group per flushed batch, so only the current
+// batch is held in memory. T is the row layout and row converts a genre to it.
+type parquetWriter[T any] struct {
+	out    io.Writer
+	writer *parquet.GenericWriter[T]
+	rows   []T
+	row    func(Genre) T
+}
+
+func newParquetWriter(out io.Writer) genreWriter {
+	if *numericWeights {
+		return newParquetRows(out, numericParquetRow)
+	}
+	return newParquetRows(out, parquetRow)
+}
+
+func newParquetRows[T any](out io.Writer, row func(Genre) T) *parquetWriter[T] {
+	return &parquetWriter[T]{
+		out:    out,
+		writer: parquet.NewGenericWriter[T](out),
+		rows:   make([]T, 0, batchSize),
+		row:    row,
+	}
+}
+
+func parquetRow(genre Genre) parquetGenre {
+	row := parquetGenre{
+		Name:            genre.Name,
+		CanonicalName:   genre.CanonicalName,
+		Playlist:        genre.Playlist,
+		FontSize:        genre.FontSize,
+		ColorHex:        genre.ColorHex,
+		ColorRGB:        genre.ColorRGB,
+		Top:             genre.Top,
+		Left:            genre.Left,
+		ArtistWeights:   genre.ArtistWeights,
+		Artists:         genre.Artists,
+		SimWeights:      genre.SimWeights,
+		SimGenres:       genre.SimGenres,
+		OppWeights:      genre.OppWeights,
+		OppGenres:       genre.OppGenres,
+		ArtistCountHint: int64(genre.ArtistCountHint),
+		MapLinks:        genre.MapLinks,
+		MapLinkLabels:   genre.MapLinkLabels,
+	}
+	if *styleExtras {
+		row.ZIndex = &genre.ZIndex
+		row.Opacity = &genre.Opacity
+	}
+	return row
+}
+
+func numericParquetRow(genre Genre) parquetNumericGenre {
+	row := parquetNumericGenre{
+		Name:            genre.Name,
+		CanonicalName:   genre.CanonicalName,
+		Playlist:        genre.Playlist,
+		FontSize:        genre.FontSize,
+		ColorHex:        genre.ColorHex,
+		ColorRGB:        genre.ColorRGB,
+		Top:             genre.Top,
+		Left:            genre.Left,
+		ArtistWeights:   parquetWeights(genre.ArtistWeights),
+		Artists:         genre.Artists,
+		SimWeights:      parquetWeights(genre.SimWeights),
+		SimGenres:       genre.SimGenres,
+		OppWeights:      parquetWeights(genre.OppWeights),
+		OppGenres:       genre.OppGenres,
+		ArtistCountHint: int64(genre.ArtistCountHint),
+		MapLinks:        genre.MapLinks,
+		MapLinkLabels:   genre.MapLinkLabels,
+	}
+	if *styleExtras {
+		row.ZIndex = &genre.ZIndex
+		row.Opacity = &genre.Opacity
+	}
+	return row
+}
+
+// parquetWeights parses weights for a list<double> column. checkWeights has
+// already replaced anything that isn't a number with "0"; an empty weight,
+// e.g. from a merged file, is written as 0 too.
+func parquetWeights(weights []string) []float64 {
+	if weights == nil {
+		return nil
+	}
+	values := make([]float64, len(weights))
+	for i, w := range weights {
+		values[i], _ = strconv.ParseFloat(w, 64)
+	}
+	return values
+}
+
+func (w *parquetWriter[T]) Write(genre Genre) error {
+	w.rows = append(w.rows, w.row(genre))
+	return nil
+}
+
+func (w *parquetWriter[T]) Flush() error {
+	if len(w.rows) == 0 {
+		return nil
+	}
+	if _, err := w.writer.Write(w.rows); err != nil {
+		return err
+	}
+	w.rows = w.rows[:0]
+	return w.writer.Flush()
+}
+
+func (w *parquetWriter[T]) Close() error {
+	if err := w.Flush(); err != nil {
+		closeOutput(w.out)
+		return err
+	}
+	if err := w.writer.Close(); err != nil {
+		closeOutput(w.out)
+		return err
+	}
+	return closeOutput(w.out)
+}
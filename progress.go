@@ -0,0 +1,110 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// rateSmoothing is the weight of the latest sample in the moving average of
+// genres per second; lower values smooth out bursts more.
+const rateSmoothing = 0.3
+
+// progress counts completed genres against the whole dataset. Genres finished
+// by an earlier run are counted as done from the start, so a run that only
+// scrapes the remainder still reports overall completion.
+type progress struct {
+	total     int32
+	done      int32
+	failed    int32
+	completed int32 // genres finished before this run
+
+	mu      sync.Mutex
+	missing []string // genres with no page, see errPageMissing
+
+	// An exponential moving average of genres finished per second in this
+	// run, whether scraped, failed or missing, updated each time progress
+	// is logged.
+	rate         float64
+	lastSample   time.Time
+	lastFinished int32
+}
+
+func newProgress(total, completed int) *progress {
+	return &progress{total: int32(total), done: int32(completed), completed: int32(completed), lastSample: time.Now()}
+}
+
+// complete records a genre finished in this run, logging every 100 genres
+// and at the end.
+func (p *progress) complete() {
+	done := atomic.AddInt32(&p.done, 1)
+	if done%100 == 0 || done == p.total {
+		log.Printf("Processed %d/%d genres%s", done, p.total, p.speed())
+	}
+}
+
+// speed updates the moving average and formats it with the time left for the
+// remaining genres at that rate.
+func (p *progress) speed() string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	done := atomic.LoadInt32(&p.done)
+	finished := done - p.completed + p.failures() + int32(len(p.missing))
+	now := time.Now()
+	if elapsed := now.Sub(p.lastSample).Seconds(); elapsed > 0 && finished > p.lastFinished {
+		sample := float64(finished-p.lastFinished) / elapsed
+		if p.rate == 0 {
+			p.rate = sample
+		} else {
+			p.rate = rateSmoothing*sample + (1-rateSmoothing)*p.rate
+		}
+		p.lastSample = now
+		p.lastFinished = finished
+	}
+	if p.rate == 0 {
+		return ""
+	}
+
+	remaining := p.total - done - p.failures() - int32(len(p.missing))
+	if remaining <= 0 {
+		return fmt.Sprintf(", %.1f genres/s", p.rate)
+	}
+	eta := time.Duration(float64(remaining) / p.rate * float64(time.Second))
+	return fmt.Sprintf(", %.1f genres/s, about %v left", p.rate, eta.Round(time.Second))
+}
+
+func (p *progress) fail() {
+	atomic.AddInt32(&p.failed, 1)
+}
+
+func (p *progress) failures() int32 {
+	return atomic.LoadInt32(&p.failed)
+}
+
+// miss records a genre whose page is missing or empty. These are kept apart
+// from failures: retrying won't help and they don't affect the exit code.
+func (p *progress) miss(name string) {
+	p.mu.Lock()
+	p.missing = append(p.missing, name)
+	p.mu.Unlock()
+}
+
+// missingPages returns the genres recorded by miss, sorted by name.
+func (p *progress) missingPages() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	missing := append([]string(nil), p.missing...)
+	sort.Strings(missing)
+	return missing
+}
+
+func (p *progress) log() {
+	p.mu.Lock()
+	missing := len(p.missing)
+	p.mu.Unlock()
+	log.Printf("Progress: %d/%d genres processed, %d failed, %d missing%s", atomic.LoadInt32(&p.done), p.total, p.failures(), missing, p.speed())
+}
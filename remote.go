@@ -0,0 +1,42 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+)
+
+// remoteOutputs opens an upload to an object storage URL such as
+// s3://bucket/key. Schemes are registered by remote_cloud.go, which is only
+// built with -tags cloud.
+var remoteOutputs = map[string]func(u *url.URL) (io.WriteCloser, error){}
+
+// remoteScheme returns the scheme of an --out path that names an object
+// storage destination rather than a local file, or "".
+func remoteScheme(path string) string {
+	for _, scheme := range []string{"s3", "gs"} {
+		if strings.HasPrefix(path, scheme+"://") {
+			return scheme
+		}
+	}
+	return ""
+}
+
+// createRemote opens the object at path for writing. The object is uploaded
+// when the returned writer is closed.
+func createRemote(path string) (io.WriteCloser, error) {
+	scheme := remoteScheme(path)
+	open, ok := remoteOutputs[scheme]
+	if !ok {
+		return nil, fmt.Errorf("%s:// output is not available in this build; rebuild with -tags cloud", scheme)
+	}
+	u, err := url.Parse(path)
+	if err != nil {
+		return nil, err
+	}
+	if u.Host == "" || strings.Trim(u.Path, "/") == "" {
+		return nil, fmt.Errorf("%s needs a bucket and an object name", path)
+	}
+	return open(u)
+}
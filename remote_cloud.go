@@ -0,0 +1,195 @@
+//go:build cloud
+
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+func init() {
+	remoteOutputs["s3"] = openS3
+	remoteOutputs["gs"] = openGCS
+}
+
+// openS3 uploads to Amazon S3, or any S3-compatible store set with
+// AWS_ENDPOINT_URL, using the standard AWS_* credential variables.
+func openS3(u *url.URL) (io.WriteCloser, error) {
+	region := os.Getenv("AWS_REGION")
+	if region == "" {
+		region = "us-east-1"
+	}
+	endpoint := os.Getenv("AWS_ENDPOINT_URL")
+	if endpoint == "" {
+		endpoint = fmt.Sprintf("https://s3.%s.amazonaws.com", region)
+	}
+	return newObjectUpload(u, endpoint, region, awsCredentials{
+		accessKey:    os.Getenv("AWS_ACCESS_KEY_ID"),
+		secretKey:    os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		sessionToken: os.Getenv("AWS_SESSION_TOKEN"),
+	})
+}
+
+// openGCS uploads to Google Cloud Storage through its S3-compatible XML API,
+// authenticating with an HMAC key from GCS_HMAC_ACCESS_KEY and
+// GCS_HMAC_SECRET.
+func openGCS(u *url.URL) (io.WriteCloser, error) {
+	return newObjectUpload(u, "https://storage.googleapis.com", "auto", awsCredentials{
+		accessKey: os.Getenv("GCS_HMAC_ACCESS_KEY"),
+		secretKey: os.Getenv("GCS_HMAC_SECRET"),
+	})
+}
+
+// uploadClient sends the final PUT. It has its own timeout, since the upload
+// only starts once the crawl has finished and must not hang the run.
+var uploadClient = &http.Client{Timeout: 10 * time.Minute}
+
+type awsCredentials struct {
+	accessKey    string
+	secretKey    string
+	sessionToken string
+}
+
+// objectUpload spools the output to a temporary file, since a single PUT
+// needs the length and checksum up front, and uploads it on Close.
+type objectUpload struct {
+	url    string
+	region string
+	creds  awsCredentials
+	tmp    *os.File
+	sum    hash.Hash
+	w      io.Writer
+}
+
+func newObjectUpload(u *url.URL, endpoint, region string, creds awsCredentials) (*objectUpload, error) {
+	if creds.accessKey == "" || creds.secretKey == "" {
+		return nil, fmt.Errorf("no credentials for %s://%s", u.Scheme, u.Host)
+	}
+	tmp, err := os.CreateTemp("", "enaoscrape-upload-*")
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.New()
+	return &objectUpload{
+		url:    strings.TrimSuffix(endpoint, "/") + "/" + u.Host + uriEncodePath(u.Path),
+		region: region,
+		creds:  creds,
+		tmp:    tmp,
+		sum:    sum,
+		w:      io.MultiWriter(tmp, sum),
+	}, nil
+}
+
+func (o *objectUpload) Write(p []byte) (int, error) {
+	return o.w.Write(p)
+}
+
+func (o *objectUpload) Close() error {
+	defer os.Remove(o.tmp.Name())
+	defer o.tmp.Close()
+
+	size, err := o.tmp.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	if _, err := o.tmp.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	req, err := http.NewRequest("PUT", o.url, io.NopCloser(o.tmp))
+	if err != nil {
+		return err
+	}
+	req.ContentLength = size
+	signV4(req, hex.EncodeToString(o.sum.Sum(nil)), o.region, o.creds, time.Now())
+
+	res, err := uploadClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("error uploading %s: %v", o.url, err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(res.Body, 1024))
+		return fmt.Errorf("error uploading %s: HTTP %s: %s", o.url, res.Status, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
+
+// signV4 adds AWS Signature Version 4 headers to req for the s3 service.
+func signV4(req *http.Request, payloadHash, region string, creds awsCredentials, now time.Time) {
+	now = now.UTC()
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	if creds.sessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", creds.sessionToken)
+	}
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name := range req.Header {
+		headers[strings.ToLower(name)] = strings.TrimSpace(req.Header.Get(name))
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+	scope := date + "/" + region + "/s3/aws4_request"
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+
+	key := hmacSHA256([]byte("AWS4"+creds.secretKey), date)
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		creds.accessKey, scope, signedHeaders, signature))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// uriEncodePath escapes an object key the way SigV4 expects: every byte but
+// unreserved characters and the slashes between segments.
+func uriEncodePath(path string) string {
+	var b strings.Builder
+	for i := 0; i < len(path); i++ {
+		c := path[i]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' || strings.IndexByte("-_.~/", c) >= 0 {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
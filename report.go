@@ -0,0 +1,88 @@
+package main
+
+import (
+	"html/template"
+	"os"
+)
+
+// reportCollector keeps what the HTML report plots for every written genre.
+// Its add method is registered as an onGenre hook.
+type reportCollector struct {
+	points []reportPoint
+	width  float64
+	height float64
+
+	// missing lists genres whose page is missing or empty
+	missing []string
+}
+
+type reportPoint struct {
+	Name    string
+	Color   string
+	X, Y    float64
+	Artists int
+}
+
+func (r *reportCollector) add(genre Genre) {
+	if genre.Error != "" || genre.Top == "" || genre.Left == "" {
+		return
+	}
+	p := reportPoint{
+		Name:    genre.Name,
+		Color:   genre.ColorHex,
+		X:       cssNumber(genre.Left),
+		Y:       cssNumber(genre.Top),
+		Artists: len(genre.Artists),
+	}
+	if p.Color == "" {
+		p.Color = "#888888"
+	}
+	r.points = append(r.points, p)
+	r.width = max(r.width, p.X)
+	r.height = max(r.height, p.Y)
+}
+
+var reportTemplate = template.Must(template.New("report").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Every Noise at Once genre map</title>
+<style>
+body { margin: 0; background: #111; color: #ddd; font-family: sans-serif; }
+header { padding: 8px 16px; }
+svg { display: block; width: 100%; height: auto; }
+circle:hover { stroke: #fff; stroke-width: 4; }
+</style>
+</head>
+<body>
+<header>{{len .Points}} genres. Hover a point for its name and artist count.</header>
+{{- if .Missing}}
+<header>{{len .Missing}} genres are listed on the map but have no genre page: {{range $i, $name := .Missing}}{{if $i}}, {{end}}{{$name}}{{end}}</header>
+{{- end}}
+<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 {{.Width}} {{.Height}}">
+{{- range .Points}}
+<circle cx="{{.X}}" cy="{{.Y}}" r="6" fill="{{.Color}}"><title>{{.Name}}: {{.Artists}} artists</title></circle>
+{{- end}}
+</svg>
+</body>
+</html>
+`))
+
+// write renders a self-contained HTML page plotting each genre at its map
+// position in its map color.
+func (r *reportCollector) write(path string) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	err = reportTemplate.Execute(file, struct {
+		Points        []reportPoint
+		Width, Height float64
+		Missing       []string
+	}{r.points, r.width + 20, r.height + 20, r.missing})
+	if err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
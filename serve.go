@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+)
+
+// genreServer scrapes genres on demand and caches them for the lifetime of
+// the process. Requests share the package rate limiter and HTTP client with
+// the batch scraper.
+type genreServer struct {
+	mu     sync.RWMutex
+	cache  map[string]Genre
+	flight singleflight.Group
+}
+
+func serve(addr string) error {
+	gs := &genreServer{cache: make(map[string]Genre)}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /genre/{name}", gs.handleGenre)
+
+	server := &http.Server{
+		Addr:              addr,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	log.Printf("Serving genres on %s", addr)
+	return server.ListenAndServe()
+}
+
+func (gs *genreServer) handleGenre(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimSpace(r.PathValue("name"))
+	if name == "" {
+		http.Error(w, "missing genre name", http.StatusBadRequest)
+		return
+	}
+
+	genre, err := gs.genre(r, name)
+	if err != nil {
+		log.Printf("Error serving %s: %v", name, err)
+		status := http.StatusBadGateway
+		switch {
+		case errors.Is(err, errPageMissing):
+			status = http.StatusNotFound
+		case errors.Is(err, errThrottled):
+			status = http.StatusServiceUnavailable
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(newJSONGenre(genre)); err != nil {
+		log.Printf("Error encoding %s: %v", name, err)
+	}
+}
+
+// genre returns the cached genre or scrapes it. Concurrent requests for the
+// same genre share a single fetch, which isn't cancelled when the client that
+// started it goes away.
+func (gs *genreServer) genre(r *http.Request, name string) (Genre, error) {
+	gs.mu.RLock()
+	genre, ok := gs.cache[name]
+	gs.mu.RUnlock()
+	if ok {
+		return genre, nil
+	}
+
+	v, err, _ := gs.flight.Do(name, func() (interface{}, error) {
+		// The fetch is shared, so it must outlive the request that started it
+		// if that client disconnects; --genre-timeout bounds it instead
+		ctx := context.WithoutCancel(r.Context())
+		if *genreTimeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, *genreTimeout)
+			defer cancel()
+		}
+		genre, err := scrapeGenreData(ctx, name)
+		if err != nil {
+			return Genre{}, err
+		}
+		genre.Name = name
+
+		gs.mu.Lock()
+		gs.cache[name] = genre
+		gs.mu.Unlock()
+		return genre, nil
+	})
+	return v.(Genre), err
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"fmt"
+	"hash/fnv"
+	"strconv"
+	"strings"
+)
+
+// parseShard parses --shard "i/n", where shards are numbered 0 to n-1.
+func parseShard(spec string) (i, n uint32, err error) {
+	index, count, ok := strings.Cut(spec, "/")
+	if !ok {
+		return 0, 0, fmt.Errorf("--shard must be i/n, e.g. 0/4")
+	}
+	i64, err1 := strconv.ParseUint(index, 10, 32)
+	n64, err2 := strconv.ParseUint(count, 10, 32)
+	if err1 != nil || err2 != nil || n64 == 0 || i64 >= n64 {
+		return 0, 0, fmt.Errorf("--shard must be i/n with 0 <= i < n, got %q", spec)
+	}
+	return uint32(i64), uint32(n64), nil
+}
+
+// shardGenres keeps the genres in shard i of n. A genre's shard is a hash of
+// its page slug, so it doesn't depend on the order or size of the genre list
+// and reruns of a shard cover the same genres. Two names for the same page
+// always land in the same shard.
+func shardGenres(genres []Genre, i, n uint32) []Genre {
+	var shard []Genre
+	for _, genre := range genres {
+		h := fnv.New32a()
+		h.Write([]byte(slugify(genre.Name)))
+		if h.Sum32()%n == i {
+			shard = append(shard, genre)
+		}
+	}
+	return shard
+}
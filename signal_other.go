@@ -0,0 +1,8 @@
+//go:build !unix
+
+package main
+
+// notifyFlushSignal is a no-op on platforms without SIGUSR1.
+func notifyFlushSignal(fn func()) (stop func()) {
+	return func() {}
+}
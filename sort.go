@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// genreLess orders genres for --sort. Ties fall back to the genre name so the
+// order never depends on completion order.
+var genreLess = map[string]func(a, b Genre) bool{
+	"name": func(a, b Genre) bool {
+		return a.Name < b.Name
+	},
+	"position": func(a, b Genre) bool {
+		if at, bt := cssNumber(a.Top), cssNumber(b.Top); at != bt {
+			return at < bt
+		}
+		if al, bl := cssNumber(a.Left), cssNumber(b.Left); al != bl {
+			return al < bl
+		}
+		return a.Name < b.Name
+	},
+	"artists": func(a, b Genre) bool {
+		if len(a.Artists) != len(b.Artists) {
+			return len(a.Artists) > len(b.Artists)
+		}
+		return a.Name < b.Name
+	},
+}
+
+func validateSortKey(key string) error {
+	if _, ok := genreLess[key]; key != "" && !ok {
+		return fmt.Errorf("unknown sort key %q (want name, position or artists)", key)
+	}
+	return nil
+}
+
+// sortResults drains results and returns a channel yielding the same genres
+// sorted by key. Every genre is held in memory until the run finishes.
+func sortResults(results <-chan Genre, key string) <-chan Genre {
+	var genres []Genre
+	for genre := range results {
+		genres = append(genres, genre)
+	}
+
+	less := genreLess[key]
+	sort.SliceStable(genres, func(i, j int) bool { return less(genres[i], genres[j]) })
+	log.Printf("Sorted %d genres by %s", len(genres), key)
+
+	sorted := make(chan Genre, len(genres))
+	for _, genre := range genres {
+		sorted <- genre
+	}
+	close(sorted)
+	return sorted
+}
+
+// cssNumber parses the numeric part of a CSS length such as "1234px".
+func cssNumber(value string) float64 {
+	value = strings.TrimRight(strings.TrimSpace(value), "abcdefghijklmnopqrstuvwxyz%")
+	n, _ := strconv.ParseFloat(value, 64)
+	return n
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+// styleParser extracts map attributes from an element's inline style. Each
+// property has its own pattern whose first group captures the value, so a
+// pattern can be replaced when everynoise changes its style format.
+type styleParser struct {
+	fontSize *regexp.Regexp
+	color    *regexp.Regexp
+	top      *regexp.Regexp
+	left     *regexp.Regexp
+	zIndex   *regexp.Regexp
+	opacity  *regexp.Regexp
+}
+
+// styleAttributes are the values parsed from one inline style.
+type styleAttributes struct {
+	FontSize string
+	ColorHex string
+	ColorRGB string
+	Top      string
+	Left     string
+	ZIndex   string
+	Opacity  string
+}
+
+func newStyleParser() *styleParser {
+	return &styleParser{
+		fontSize: regexp.MustCompile(`font-size:([^;]+)`),
+		color:    regexp.MustCompile(`color:([^;]+)`),
+		top:      regexp.MustCompile(`top:([^;]+)`),
+		left:     regexp.MustCompile(`left:([^;]+)`),
+		zIndex:   regexp.MustCompile(`z-index:([^;]+)`),
+		opacity:  regexp.MustCompile(`opacity:([^;]+)`),
+	}
+}
+
+var styles = newStyleParser()
+
+func (p *styleParser) attributes(style string) styleAttributes {
+	attrs := styleAttributes{
+		FontSize: fontSize(property(p.fontSize, style)),
+		ColorHex: property(p.color, style),
+		Top:      property(p.top, style),
+		Left:     property(p.left, style),
+		ZIndex:   property(p.zIndex, style),
+		Opacity:  property(p.opacity, style),
+	}
+	if attrs.ColorHex != "" {
+		r, g, b := hexToRGB(attrs.ColorHex)
+		attrs.ColorRGB = fmt.Sprintf("rgb(%d, %d, %d)", r, g, b)
+	}
+	return attrs
+}
+
+// weight returns the font size of an artist or related genre, normalized the
+// same way as a genre's FontSize.
+func (p *styleParser) weight(style string) string {
+	return fontSize(property(p.fontSize, style))
+}
+
+// Font sizes are converted using the CSS defaults: 100% = 1em = 16px = 12pt.
+var fontSizeUnits = map[string]float64{
+	"%":  1,
+	"em": 100,
+	"px": 100.0 / 16,
+	"pt": 100.0 / 12,
+}
+
+// fontSize converts a CSS font-size such as "120%", "24px" or "18pt" to a bare
+// number in --weight-unit, "percent" or "px", rounded to two decimals. A value
+// without a unit is taken to already be in that unit. Values that can't be
+// parsed, e.g. "larger", are returned unchanged.
+func fontSize(value string) string {
+	number := strings.TrimRight(value, "%abcdefghijklmnopqrstuvwxyz")
+	n, err := strconv.ParseFloat(number, 64)
+	if err != nil {
+		return value
+	}
+	if unit := value[len(number):]; unit != "" {
+		percent, ok := fontSizeUnits[unit]
+		if !ok {
+			return value
+		}
+		n *= percent
+		if *weightUnit == "px" {
+			n /= fontSizeUnits["px"]
+		}
+	}
+	return strconv.FormatFloat(math.Round(n*100)/100, 'f', -1, 64)
+}
+
+// malformedWeights counts the weights --numeric-weights replaced with 0.
+var malformedWeights int32
+
+// checkWeights replaces every weight that isn't a plain number, e.g. because
+// the font-size pattern captured trailing text, with "0".
+func checkWeights(genre string, weights []string) {
+	for i, w := range weights {
+		if !isNumber(w) {
+			atomic.AddInt32(&malformedWeights, 1)
+			if *verbose {
+				log.Printf("Weight %q on %s is not a number, using 0", w, genre)
+			}
+			weights[i] = "0"
+		}
+	}
+}
+
+// isNumber reports whether s is a decimal number that can be written as a
+// JSON number as is.
+func isNumber(s string) bool {
+	_, err := strconv.ParseFloat(s, 64)
+	return err == nil && json.Valid([]byte(s))
+}
+
+// property returns the trimmed first group of re in style, or "".
+func property(re *regexp.Regexp, style string) string {
+	if match := re.FindStringSubmatch(style); len(match) > 1 {
+		return strings.TrimSpace(match[1])
+	}
+	return ""
+}
+
+func hexToRGB(hex string) (r, g, b int) {
+	fmt.Sscanf(hex, "#%02x%02x%02x", &r, &g, &b)
+	return
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"regexp"
+	"testing"
+)
+
+func TestStyleAttributes(t *testing.T) {
+	p := newStyleParser()
+
+	tests := []struct {
+		name  string
+		style string
+		want  styleAttributes
+	}{
+		{
+			name:  "map genre",
+			style: "color: #b38e1f; top: 3968px; left: 1194px; font-size: 116%",
+			want: styleAttributes{
+				FontSize: "116",
+				ColorHex: "#b38e1f",
+				ColorRGB: "rgb(179, 142, 31)",
+				Top:      "3968px",
+				Left:     "1194px",
+			},
+		},
+		{
+			name:  "style extras",
+			style: "color: #30a2cc; top: 20px; left: 455px; font-size: 137%; z-index: 3; opacity: 0.75",
+			want: styleAttributes{
+				FontSize: "137",
+				ColorHex: "#30a2cc",
+				ColorRGB: "rgb(48, 162, 204)",
+				Top:      "20px",
+				Left:     "455px",
+				ZIndex:   "3",
+				Opacity:  "0.75",
+			},
+		},
+		{
+			name:  "no spaces, trailing semicolon",
+			style: "font-size:120%;color:#000000;top:0px;left:-12px;",
+			want: styleAttributes{
+				FontSize: "120",
+				ColorHex: "#000000",
+				ColorRGB: "rgb(0, 0, 0)",
+				Top:      "0px",
+				Left:     "-12px",
+			},
+		},
+		{
+			name:  "empty",
+			style: "",
+			want:  styleAttributes{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := p.attributes(tt.style); got != tt.want {
+				t.Errorf("attributes(%q) = %+v, want %+v", tt.style, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStyleWeight(t *testing.T) {
+	p := newStyleParser()
+	style := "color: #a6904c; top: 62px; left: 642px; font-size: 101%"
+	if got := p.weight(style); got != "101" {
+		t.Errorf("weight(%q) = %s, want 101", style, got)
+	}
+}
+
+// A pattern can be swapped when everynoise changes its style format.
+func TestStyleParserOverride(t *testing.T) {
+	p := newStyleParser()
+	p.top = regexp.MustCompile(`inset-block-start:([^;]+)`)
+
+	got := p.attributes("inset-block-start: 15px; top: 99px")
+	if got.Top != "15px" {
+		t.Errorf("Top = %s, want 15px from the replaced pattern", got.Top)
+	}
+}
+
+func TestFontSize(t *testing.T) {
+	tests := []struct {
+		value, percent, px string
+	}{
+		{"120%", "120", "19.2"},
+		{"100%", "100", "16"},
+		{"24px", "150", "24"},
+		{"13px", "81.25", "13"},
+		{"18pt", "150", "24"},
+		{"10pt", "83.33", "13.33"},
+		{"1.5em", "150", "24"},
+		{"120", "120", "120"}, // no unit: already in --weight-unit
+		{"larger", "larger", "larger"},
+		{"10vw", "10vw", "10vw"},
+		{"", "", ""},
+	}
+	saved := *weightUnit
+	defer func() { *weightUnit = saved }()
+	for _, unit := range []string{"percent", "px"} {
+		*weightUnit = unit
+		for _, tt := range tests {
+			want := tt.percent
+			if unit == "px" {
+				want = tt.px
+			}
+			if got := fontSize(tt.value); got != want {
+				t.Errorf("fontSize(%q) with --weight-unit %s = %q, want %q", tt.value, unit, got, want)
+			}
+		}
+	}
+}
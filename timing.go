@@ -0,0 +1,65 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Time spent across all workers, in nanoseconds, for the timing breakdown at
+// the end of a run. These overlap and can add up to more than the run itself.
+var (
+	limiterTime int64 // waiting for a rate limiter token
+	requestTime int64 // sending requests and reading responses, including retries
+	parseTime   int64 // parsing genre pages
+	writeTime   int64 // writing and flushing output, in the writer goroutine
+)
+
+func addTime(counter *int64, start time.Time) {
+	atomic.AddInt64(counter, int64(time.Since(start)))
+}
+
+func loadTime(counter *int64) time.Duration {
+	return time.Duration(atomic.LoadInt64(counter)).Round(time.Millisecond)
+}
+
+// timedWriter adds the time spent in a genreWriter to writeTime.
+type timedWriter struct {
+	genreWriter
+}
+
+func (w timedWriter) Write(genre Genre) error {
+	defer addTime(&writeTime, time.Now())
+	return w.genreWriter.Write(genre)
+}
+
+func (w timedWriter) Flush() error {
+	defer addTime(&writeTime, time.Now())
+	return w.genreWriter.Flush()
+}
+
+func (w timedWriter) Close() error {
+	defer addTime(&writeTime, time.Now())
+	return w.genreWriter.Close()
+}
+
+// phase is one consecutive part of a run and how long it took.
+type phase struct {
+	name     string
+	duration time.Duration
+}
+
+// logTimings logs how long each phase of the run took, then where the workers
+// and the writer spent their time, to show whether the limiter, the network,
+// parsing or the output is the bottleneck.
+func logTimings(phases []phase, workers int) {
+	parts := make([]string, len(phases))
+	for i, p := range phases {
+		parts[i] = fmt.Sprintf("%s %v", p.name, p.duration.Round(time.Millisecond))
+	}
+	log.Printf("Phases: %s", strings.Join(parts, ", "))
+	log.Printf("Time summed over %d workers: rate limiter %v, requests %v, parsing %v; writer %v",
+		workers, loadTime(&limiterTime), loadTime(&requestTime), loadTime(&parseTime), loadTime(&writeTime))
+}
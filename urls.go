@@ -0,0 +1,70 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"unicode"
+)
+
+const everynoiseBase = "https://everynoise.com"
+
+// slugify turns a genre name into the form everynoise uses in page names,
+// which is also safe as a file name: letters and digits only, lowercased, so
+// "Hip Hop" and "hip-hop" both become "hiphop" and "r&b" becomes "rb".
+// Non-ASCII letters are kept, e.g. "forró" stays "forró".
+func slugify(name string) string {
+	var b strings.Builder
+	for _, r := range name {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			b.WriteRune(unicode.ToLower(r))
+		}
+	}
+	return b.String()
+}
+
+// genreURL returns the page for a genre name on the site at base, e.g.
+// https://everynoise.com/engenremap-shoegaze.html. Non-ASCII letters in the
+// slug are percent-encoded.
+func genreURL(base, name string) string {
+	return strings.TrimSuffix(base, "/") + "/engenremap-" + url.PathEscape(slugify(name)) + ".html"
+}
+
+// readGenreURLs reads one genre page URL per line, skipping blank lines and
+// lines starting with #. Each genre is named after its URL.
+func readGenreURLs(file string) ([]Genre, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var genres []Genre
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		u, err := url.Parse(line)
+		if err != nil || u.Scheme == "" || u.Host == "" {
+			return nil, fmt.Errorf("invalid genre URL %q", line)
+		}
+		genres = append(genres, Genre{Name: genreNameFromURL(u), URL: line})
+	}
+	return genres, scanner.Err()
+}
+
+// genreNameFromURL turns ".../engenremap-shoegaze.html" into "shoegaze".
+func genreNameFromURL(u *url.URL) string {
+	name := path.Base(u.Path)
+	name = strings.TrimSuffix(name, ".html")
+	name = strings.TrimPrefix(name, "engenremap-")
+	if unescaped, err := url.QueryUnescape(name); err == nil {
+		name = unescaped
+	}
+	return name
+}
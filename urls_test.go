@@ -0,0 +1,47 @@
+package main
+
+import "testing"
+
+func TestSlugify(t *testing.T) {
+	tests := []struct {
+		name, want string
+	}{
+		{"shoegaze", "shoegaze"},
+		{"dream pop", "dreampop"},
+		{"  hip  hop ", "hiphop"},
+		{"Hip Hop", "hiphop"},
+		{"hip-hop", "hiphop"},
+		{"r&b", "rb"},
+		{"drum and bass", "drumandbass"},
+		{"k-pop", "kpop"},
+		{"children's music", "childrensmusic"},
+		{"no/wave (nyc)", "nowavenyc"},
+		{"8-bit", "8bit"},
+		{"forró", "forró"},
+		{"Música Mexicana", "músicamexicana"},
+		{"j-pop 日本", "jpop日本"},
+		{"!!!", ""},
+	}
+	for _, tt := range tests {
+		if got := slugify(tt.name); got != tt.want {
+			t.Errorf("slugify(%q) = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestGenreURL(t *testing.T) {
+	tests := []struct {
+		base, name, want string
+	}{
+		{everynoiseBase, "shoegaze", "https://everynoise.com/engenremap-shoegaze.html"},
+		{everynoiseBase, "dream pop", "https://everynoise.com/engenremap-dreampop.html"},
+		{everynoiseBase, "r&b", "https://everynoise.com/engenremap-rb.html"},
+		{everynoiseBase, "forró", "https://everynoise.com/engenremap-forr%C3%B3.html"},
+		{"http://localhost:8080/", "Hip Hop", "http://localhost:8080/engenremap-hiphop.html"},
+	}
+	for _, tt := range tests {
+		if got := genreURL(tt.base, tt.name); got != tt.want {
+			t.Errorf("genreURL(%q, %q) = %s, want %s", tt.base, tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+)
+
+// genreCheck is a --validate assertion that every scraped genre must pass.
+type genreCheck struct {
+	name        string
+	description string
+	fails       func(Genre) bool
+}
+
+var genreChecks = []genreCheck{
+	{"color", "every genre has a color", func(g Genre) bool { return g.ColorHex == "" }},
+	{"position", "every genre has a map position", func(g Genre) bool { return g.Top == "" || g.Left == "" }},
+	{"playlist", "every genre has a playlist", func(g Genre) bool { return g.Playlist == "" }},
+	{"artists", "no genre has zero artists", func(g Genre) bool { return len(g.Artists) == 0 }},
+	{"weights", "every artist has a weight", func(g Genre) bool { return len(g.ArtistWeights) != len(g.Artists) }},
+}
+
+// minGenresCheck is the one check on the whole set rather than each genre.
+const minGenresCheck = "min-genres"
+
+// validator runs the --validate checks over the written genres. Its add method
+// is registered as an onGenre hook. Genres written with an error by
+// --include-failed are not checked.
+type validator struct {
+	checks    []genreCheck
+	minGenres int // 0 unless min-genres is enabled
+	genres    int
+	failures  map[string][]string // check name to failing genres
+}
+
+// newValidator enables the comma-separated checks in spec, or every check for
+// "all". minGenres is the threshold for min-genres.
+func newValidator(spec string, minGenres int) (*validator, error) {
+	v := &validator{failures: make(map[string][]string)}
+	for _, name := range strings.Split(spec, ",") {
+		name = strings.TrimSpace(name)
+		switch name {
+		case "":
+			continue
+		case "all":
+			v.checks = genreChecks
+			v.minGenres = minGenres
+			continue
+		case minGenresCheck:
+			v.minGenres = minGenres
+			continue
+		}
+		check, ok := findGenreCheck(name)
+		if !ok {
+			return nil, fmt.Errorf("unknown --validate check %q", name)
+		}
+		v.checks = append(v.checks, check)
+	}
+	return v, nil
+}
+
+func findGenreCheck(name string) (genreCheck, bool) {
+	for _, check := range genreChecks {
+		if check.name == name {
+			return check, true
+		}
+	}
+	return genreCheck{}, false
+}
+
+func (v *validator) add(genre Genre) {
+	if genre.Error != "" {
+		return
+	}
+	v.genres++
+	for _, check := range v.checks {
+		if check.fails(genre) {
+			v.failures[check.name] = append(v.failures[check.name], genre.Name)
+		}
+	}
+}
+
+// report logs the result of every enabled check and returns whether all of
+// them passed.
+func (v *validator) report() bool {
+	passed := true
+	if v.minGenres > 0 {
+		if v.genres < v.minGenres {
+			log.Printf("Validation failed: %s: %d genres scraped, want at least %d", minGenresCheck, v.genres, v.minGenres)
+			passed = false
+		} else {
+			log.Printf("Validation passed: %s: %d genres scraped", minGenresCheck, v.genres)
+		}
+	}
+	for _, check := range v.checks {
+		failing := v.failures[check.name]
+		if len(failing) == 0 {
+			log.Printf("Validation passed: %s: %s", check.name, check.description)
+			continue
+		}
+		examples := failing
+		if len(examples) > 10 {
+			examples = examples[:10]
+		}
+		log.Printf("Validation failed: %s: %s, but %d/%d genres don't, e.g. %s", check.name, check.description, len(failing), v.genres, strings.Join(examples, ", "))
+		passed = false
+	}
+	return passed
+}